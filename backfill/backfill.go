@@ -0,0 +1,122 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"people2/config"
+	db "people2/database"
+	"people2/enrich"
+	"people2/logging"
+	"people2/models"
+	"people2/requests"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	log = logging.Config
+	// The pause between the backfill passes.
+	Interval = config.Duration("BACKFILL_INTERVAL", time.Minute)
+	// The number of entries processed in one pass.
+	BatchSize = config.Int("BACKFILL_BATCH", 50)
+	// The number of passes after which the pending fields are failed.
+	MaxAttempts = config.Int("BACKFILL_MAX_ATTEMPTS", 10)
+)
+
+// The function runs the backfill passes in the background until the
+// context is done.
+func Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				Run(ctx)
+			}
+		}
+	}()
+}
+
+// The function retries the lookups of the pending fields of the oldest
+// entries. The lookups run concurrently, so that the names are sent to
+// the providers in batches. The fields that are still pending after
+// MaxAttempts passes are marked as failed, as well as the low
+// confidence fields in the reject mode. Returns the number of completed
+// entries.
+func Run(ctx context.Context) (int, error) {
+	f := logging.F()
+	var entries []models.Entry
+	err := db.C.
+		Where(
+			"age_status = ? OR gender_status = ? OR nationality_status = ?",
+			models.StatusPending, models.StatusPending, models.StatusPending,
+		).
+		Order("updated_at").
+		Limit(BatchSize).
+		Find(&entries).
+		Error
+	if err != nil {
+		log.Error(f+"failed to load pending entries: ", err)
+		return 0, err
+	}
+	ctx = requests.Bulk(ctx)
+	errs := make([]error, len(entries))
+	looked := make([][]enrich.Field, len(entries))
+	var lookups sync.WaitGroup
+	for i := range entries {
+		looked[i] = entries[i].Pending()
+		lookups.Add(1)
+		go func(i int) {
+			defer lookups.Done()
+			errs[i] = entries[i].EnrichPending(ctx)
+		}(i)
+	}
+	lookups.Wait()
+	done := 0
+	for i := range entries {
+		if ctx.Err() != nil {
+			return done, ctx.Err()
+		}
+		entry := &entries[i]
+		if err := errs[i]; err != nil {
+			log.Warn(f+"backfill of entry ", entry.ID, " failed: ", err)
+		}
+		entry.EnrichAttempts++
+		pending := entry.Pending()
+		if len(pending) != 0 && entry.EnrichAttempts >= MaxAttempts {
+			for _, field := range pending {
+				entry.SetStatus(field, models.StatusFailed)
+			}
+			log.Warn(f+"backfill of entry ", entry.ID, " gave up: ", pending)
+		}
+		// The low confidence fields found now are failed in the reject
+		// mode, the same as they are rejected on the creation
+		var lowErrs models.ValidationErrors
+		if errors.As(entry.CheckConfidence(), &lowErrs) {
+			for _, item := range lowErrs {
+				field := enrich.Field(item.Field)
+				if slices.Contains(looked[i], field) &&
+					entry.Status(field) == models.StatusOK {
+					entry.SetStatus(field, models.StatusFailed)
+					log.Warn(
+						f+"backfill of entry ", entry.ID, ": ", item.Message,
+					)
+				}
+			}
+		}
+		err = entry.SaveEnrichment(db.C)
+		if err != nil {
+			log.Error(f+"failed to save entry: ", err)
+			continue
+		}
+		if len(entry.Pending()) == 0 {
+			done++
+		}
+	}
+	log.Debugf(f+"backfill completed %d of %d entries", done, len(entries))
+	return done, nil
+}
@@ -0,0 +1,145 @@
+package cache
+
+import (
+	"people2/config"
+	"people2/logging"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	log = logging.Config
+	// The enrichment cache shared by the providers.
+	Default = New(
+		config.Duration("CACHE_TTL", 24*time.Hour),
+		tiers()...,
+	)
+)
+
+// The interface of a cache tier.
+type Store interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte, ttl time.Duration)
+	Delete(key string)
+}
+
+// The hit/miss counters of a cache tier.
+type Stats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+type counters struct {
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+func (c *counters) count(hit bool) {
+	if hit {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+}
+
+func (c *counters) stats() Stats {
+	return Stats{Hits: c.hits.Load(), Misses: c.misses.Load()}
+}
+
+// The multi-tier cache. Tiers are checked in order and a hit in a lower
+// tier is copied to the upper ones.
+type Cache struct {
+	ttl   time.Duration
+	tiers []Store
+	names []string
+	total counters
+	each  []counters
+}
+
+// The function creates the cache with the given TTL over the tiers.
+func New(ttl time.Duration, tiers ...Store) *Cache {
+	c := &Cache{
+		ttl:   ttl,
+		tiers: tiers,
+		each:  make([]counters, len(tiers)),
+	}
+	for _, tier := range tiers {
+		c.names = append(c.names, tierName(tier))
+	}
+	return c
+}
+
+// The method returns the cached value by the key.
+func (c *Cache) Get(key string) ([]byte, bool) {
+	key = Normalize(key)
+	for i, tier := range c.tiers {
+		value, ok := tier.Get(key)
+		c.each[i].count(ok)
+		if !ok {
+			continue
+		}
+		for _, upper := range c.tiers[:i] {
+			upper.Set(key, value, c.ttl)
+		}
+		c.total.count(true)
+		return value, true
+	}
+	c.total.count(false)
+	return nil, false
+}
+
+// The method stores the value in every tier.
+func (c *Cache) Set(key string, value []byte) {
+	key = Normalize(key)
+	for _, tier := range c.tiers {
+		tier.Set(key, value, c.ttl)
+	}
+}
+
+// The method removes the value from every tier.
+func (c *Cache) Delete(key string) {
+	key = Normalize(key)
+	for _, tier := range c.tiers {
+		tier.Delete(key)
+	}
+}
+
+// The method returns the hit/miss counters of the whole cache under the
+// "total" key and of each tier under its name.
+func (c *Cache) Stats() map[string]Stats {
+	stats := map[string]Stats{"total": c.total.stats()}
+	for i, name := range c.names {
+		stats[name] = c.each[i].stats()
+	}
+	return stats
+}
+
+// The function returns the key in the canonical form: trimmed, lower
+// case, with single spaces.
+func Normalize(key string) string {
+	return strings.ToLower(strings.Join(strings.Fields(key), " "))
+}
+
+func tierName(tier Store) string {
+	switch tier.(type) {
+	case *LRU:
+		return "memory"
+	case *DB:
+		return "database"
+	default:
+		return "custom"
+	}
+}
+
+// The function builds the default tiers from the environment variables.
+func tiers() []Store {
+	if !config.Bool("CACHE_ENABLED", true) {
+		return nil
+	}
+	list := []Store{NewLRU(config.Int("CACHE_SIZE", 1024))}
+	if config.Bool("CACHE_DB", true) {
+		list = append(list, &DB{})
+	}
+	return list
+}
@@ -0,0 +1,87 @@
+package cache
+
+import (
+	db "people2/database"
+	"people2/logging"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// The model of the database tier.
+type Record struct {
+	Key       string    `gorm:"primarykey"`
+	Value     []byte    `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (Record) TableName() string {
+	return "enrichment_cache"
+}
+
+// The PostgreSQL tier stored in the enrichment_cache table. It is
+// skipped until the database connection is established.
+type DB struct{}
+
+func (DB) Get(key string) ([]byte, bool) {
+	if db.C == nil {
+		return nil, false
+	}
+	f := logging.F()
+	var record Record
+	err := db.C.
+		Where("key = ? AND expires_at > ?", key, time.Now()).
+		Limit(1).
+		Find(&record).
+		Error
+	if err != nil {
+		log.Error(f+"failed to read cache: ", err)
+		return nil, false
+	}
+	if record.Key == "" {
+		return nil, false
+	}
+	return record.Value, true
+}
+
+func (DB) Set(key string, value []byte, ttl time.Duration) {
+	if db.C == nil {
+		return
+	}
+	f := logging.F()
+	record := Record{
+		Key:       key,
+		Value:     value,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	err := db.C.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns(
+			[]string{"value", "expires_at", "updated_at"},
+		),
+	}).Create(&record).Error
+	if err != nil {
+		log.Error(f+"failed to write cache: ", err)
+	}
+}
+
+func (DB) Delete(key string) {
+	if db.C == nil {
+		return
+	}
+	f := logging.F()
+	err := db.C.Where("key = ?", key).Delete(&Record{}).Error
+	if err != nil {
+		log.Error(f+"failed to delete cache: ", err)
+	}
+}
+
+// The function removes the expired records from the database tier.
+func Purge() error {
+	if db.C == nil {
+		return nil
+	}
+	return db.C.Where("expires_at <= ?", time.Now()).Delete(&Record{}).Error
+}
@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// The in-memory tier that evicts the least recently used values.
+type LRU struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+type lruItem struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// The function creates the in-memory tier with the given capacity.
+func NewLRU(size int) *LRU {
+	if size < 1 {
+		size = 1
+	}
+	return &LRU{
+		size:  size,
+		order: list.New(),
+		items: map[string]*list.Element{},
+	}
+}
+
+func (c *LRU) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	item := elem.Value.(*lruItem)
+	if time.Now().After(item.expires) {
+		c.order.Remove(elem)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return item.value, true
+}
+
+func (c *LRU) Set(key string, value []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := time.Now().Add(ttl)
+	if elem, ok := c.items[key]; ok {
+		item := elem.Value.(*lruItem)
+		item.value = value
+		item.expires = expires
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruItem{
+		key:     key,
+		value:   value,
+		expires: expires,
+	})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruItem).key)
+	}
+}
+
+func (c *LRU) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[key]; ok {
+		c.order.Remove(elem)
+		delete(c.items, key)
+	}
+}
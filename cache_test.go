@@ -0,0 +1,40 @@
+package main
+
+import (
+	"people2/cache"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing the in-memory tier and the accounting of the cache package.
+func TestCacheLRU(t *testing.T) {
+	c := cache.New(time.Hour, cache.NewLRU(2))
+	c.Set(" Ivan ", []byte("1"))
+	c.Set("anna", []byte("2"))
+
+	value, ok := c.Get("IVAN")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("1"), value)
+
+	// "anna" is the least recently used value
+	c.Set("oleg", []byte("3"))
+	_, ok = c.Get("anna")
+	assert.False(t, ok)
+	_, ok = c.Get("oleg")
+	assert.True(t, ok)
+
+	stats := c.Stats()
+	assert.Equal(t, uint64(2), stats["total"].Hits)
+	assert.Equal(t, uint64(1), stats["total"].Misses)
+}
+
+// Testing the expiration of the cached values.
+func TestCacheTTL(t *testing.T) {
+	c := cache.New(time.Millisecond, cache.NewLRU(2))
+	c.Set("ivan", []byte("1"))
+	time.Sleep(5 * time.Millisecond)
+	_, ok := c.Get("ivan")
+	assert.False(t, ok)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"people2/config"
+	"people2/namestats"
+)
+
+const usage = `Manages the name statistics of the offline enrichment provider.
+
+Usage:
+  namestats [-dataset path] load [-replace] file.csv...
+  namestats [-dataset path] check file.csv...
+  namestats [-dataset path] stats
+
+Commands:
+  load   validates the files and merges them into the dataset, the
+         records of the same names are replaced
+  check  validates the files without changing the dataset
+  stats  prints the number of names in the dataset
+`
+
+func main() {
+	dataset := flag.String(
+		"dataset",
+		config.String("OFFLINE_DATASET", "data/namestats.csv"),
+		"path of the dataset",
+	)
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	var err error
+	switch flag.Arg(0) {
+	case "load":
+		err = load(*dataset, flag.Args()[1:])
+	case "check":
+		_, err = read(flag.Args()[1:])
+	case "stats":
+		err = stats(*dataset)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "namestats:", err)
+		os.Exit(1)
+	}
+}
+
+// Merges the files into the dataset, or replaces it with the files.
+func load(path string, args []string) error {
+	cmd := flag.NewFlagSet("load", flag.ExitOnError)
+	replace := cmd.Bool("replace", false, "replace the whole dataset")
+	cmd.Parse(args)
+	incoming, err := read(cmd.Args())
+	if err != nil {
+		return err
+	}
+	data := namestats.Dataset{}
+	if !*replace {
+		data, err = namestats.Load(path)
+		if os.IsNotExist(err) {
+			data, err = namestats.Dataset{}, nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	added, updated := data.Merge(incoming)
+	err = data.Save(path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf(
+		"%s: %d added, %d updated, %d total\n",
+		path, added, updated, len(data),
+	)
+	return nil
+}
+
+// Reads and validates the files.
+func read(files []string) (namestats.Dataset, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files given")
+	}
+	data := namestats.Dataset{}
+	for _, file := range files {
+		part, err := namestats.Load(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		data.Merge(part)
+		fmt.Printf("%s: %d names\n", file, len(part))
+	}
+	return data, nil
+}
+
+// Prints the size of the dataset.
+func stats(path string) error {
+	data, err := namestats.Load(path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: %d names\n", path, len(data))
+	return nil
+}
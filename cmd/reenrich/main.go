@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	db "people2/database"
+	"people2/enrich"
+	"people2/models"
+	"people2/pipeline"
+
+	"github.com/gin-gonic/gin"
+)
+
+const usage = `Looks up the enriched fields of the saved entries again. The
+fields set by hand are never changed.
+
+Usage:
+  reenrich [-apply] [-col column -data text] [-status status]
+           [-source provider] [-batch size]
+
+Without -apply the changes are only printed.
+`
+
+// The columns that can be filtered by their text.
+var columns = map[string]bool{
+	"name":        true,
+	"surname":     true,
+	"patronymic":  true,
+	"gender":      true,
+	"nationality": true,
+}
+
+func main() {
+	apply := flag.Bool("apply", false, "save the changes")
+	col := flag.String("col", "", "column to filter by")
+	data := flag.String("data", "", "text the column contains")
+	status := flag.String(
+		"status", "", "enrichment status of any field: ok, pending, failed",
+	)
+	source := flag.String("source", "", "provider of any field")
+	batch := flag.Int("batch", 100, "number of entries loaded at once")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if (*col == "") != (*data == "") || *col != "" && !columns[*col] ||
+		*batch < 1 || flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	gin.SetMode(gin.ReleaseMode)
+	if err := enrich.Configure(); err != nil {
+		fmt.Fprintln(os.Stderr, "reenrich:", err)
+		os.Exit(1)
+	}
+	db.Connect()
+	query := db.C.Model(&models.Entry{})
+	if *col != "" {
+		query = query.Where(*col+" LIKE ?", "%"+*data+"%")
+	}
+	if *status != "" {
+		query = query.Where(
+			"age_status = ? OR gender_status = ? OR nationality_status = ?",
+			*status, *status, *status,
+		)
+	}
+	if *source != "" {
+		query = query.Where(
+			"age_source = ? OR gender_source = ? OR nationality_source = ?",
+			*source, *source, *source,
+		)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	var total, changed, failed int
+	err := pipeline.ReenrichAll(ctx, query, *batch, *apply,
+		func(entry *models.Entry, diff *pipeline.Diff, err error) {
+			total++
+			if err != nil {
+				failed++
+				fmt.Printf("entry %d: %v\n", entry.ID, err)
+				return
+			}
+			if len(diff.Changes) != 0 {
+				changed++
+			}
+			for _, change := range diff.Changes {
+				fmt.Printf(
+					"entry %d: %s %q -> %q (%s)\n",
+					entry.ID, change.Field, change.Old, change.New,
+					change.Source,
+				)
+			}
+		},
+	)
+	verb := "would change"
+	if *apply {
+		verb = "changed"
+	}
+	fmt.Printf("%d entries: %s %d, failed %d\n", total, verb, changed, failed)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "reenrich:", err)
+		os.Exit(1)
+	}
+}
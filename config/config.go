@@ -0,0 +1,76 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/joho/godotenv/autoload"
+)
+
+// Returns the value of the environment variable, or def if it is not
+// set.
+func String(key, def string) string {
+	value, ok := os.LookupEnv(key)
+	if !ok || strings.TrimSpace(value) == "" {
+		return def
+	}
+	return strings.TrimSpace(value)
+}
+
+// Returns the integer value of the environment variable, or def if it
+// is not set or invalid.
+func Int(key string, def int) int {
+	value, err := strconv.Atoi(String(key, ""))
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// Returns the floating point value of the environment variable, or def
+// if it is not set or invalid.
+func Float(key string, def float64) float64 {
+	value, err := strconv.ParseFloat(String(key, ""), 64)
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// Returns the boolean value of the environment variable, or def if it
+// is not set or invalid.
+func Bool(key string, def bool) bool {
+	value, err := strconv.ParseBool(String(key, ""))
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// Returns the duration value of the environment variable (example: 5s,
+// 24h), or def if it is not set or invalid.
+func Duration(key string, def time.Duration) time.Duration {
+	value, err := time.ParseDuration(String(key, ""))
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// Returns the comma separated values of the environment variable, or
+// def if it is not set.
+func List(key string, def []string) []string {
+	value := String(key, "")
+	if value == "" {
+		return def
+	}
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,107 @@
+package enrich
+
+import (
+	"context"
+	"people2/cache"
+	"people2/requests"
+	"sync"
+)
+
+func init() {
+	api := Cached(API{}, cache.Default)
+	Register("api", api)
+	Register("agify", Only(api, FieldAge))
+	Register("genderize", Only(api, FieldGender))
+	Register("nationalize", Only(api, FieldNationality))
+}
+
+// The provider that obtains the requested fields from the agify,
+// genderize and nationalize APIs concurrently. The age and gender are
+// localized to the country of the query. The fields that failed
+// are reported with a PartialError.
+type API struct{}
+
+func (API) Enrich(ctx context.Context, q Query) (Result, error) {
+	var res Result
+	var mu sync.Mutex
+	errs := map[Field]error{}
+	fail := func(field Field, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs[field] = err
+	}
+	// The provenance is recorded from the trace of the response
+	found := func(field Field, trace *requests.Trace) {
+		mu.Lock()
+		defer mu.Unlock()
+		res.trace(field, Provenance{
+			Provider:   trace.Provider,
+			URL:        trace.URL,
+			Response:   trace.Response,
+			Time:       trace.Time,
+			Confidence: res.Confidence(field),
+		})
+	}
+	var tasks sync.WaitGroup
+	if q.Wants(FieldAge) {
+		tasks.Add(1)
+		go func() {
+			defer tasks.Done()
+			ctx, trace := requests.WithTrace(ctx)
+			age, err := requests.AgeIn(ctx, q.Name, q.CountryID)
+			if err != nil {
+				fail(FieldAge, err)
+				return
+			}
+			res.Age = age
+			res.AgeSource = requests.Agify.Name
+			res.AgeCountry = q.CountryID
+			found(FieldAge, trace)
+		}()
+	}
+	if q.Wants(FieldGender) {
+		tasks.Add(1)
+		go func() {
+			defer tasks.Done()
+			ctx, trace := requests.WithTrace(ctx)
+			gender, err := requests.GenderIn(ctx, q.Name, q.CountryID)
+			if err != nil {
+				fail(FieldGender, err)
+				return
+			}
+			res.Gender = gender.Gender
+			res.GenderProbability = gender.Probability
+			res.GenderCount = gender.Count
+			res.GenderSource = requests.Genderize.Name
+			res.GenderCountry = q.CountryID
+			found(FieldGender, trace)
+		}()
+	}
+	if q.Wants(FieldNationality) {
+		tasks.Add(1)
+		go func() {
+			defer tasks.Done()
+			ctx, trace := requests.WithTrace(ctx)
+			countries, err := requests.Nationality(ctx, q.Name)
+			if err != nil {
+				fail(FieldNationality, err)
+				return
+			}
+			for _, country := range countries {
+				res.Countries = append(res.Countries, Country{
+					CountryID:   country.CountryID,
+					Probability: country.Probability,
+				})
+			}
+			res.Nationality = countries[0].CountryID
+			res.NationalityProbability = countries[0].Probability
+			res.NationalitySource = requests.Nationalize.Name
+			found(FieldNationality, trace)
+		}()
+	}
+	tasks.Wait()
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+	return res, partial(errs)
+}
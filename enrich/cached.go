@@ -0,0 +1,70 @@
+package enrich
+
+import (
+	"context"
+	"encoding/json"
+	"people2/cache"
+	"people2/logging"
+)
+
+// The version of the cached Result, changed along with its fields so
+// that the outdated values are not read.
+const cacheVersion = "v6"
+
+// The function wraps the provider with the cache keyed by the
+// normalized name and the country of the localized lookups. The found
+// fields are stored, and only the fields missing in the cache are
+// looked up. The dry run lookups only read the cache, the fresh ones
+// only update it.
+func Cached(next Enricher, c *cache.Cache) Enricher {
+	return Func(func(ctx context.Context, q Query) (Result, error) {
+		f := logging.F()
+		key := cacheVersion + ":" + q.Name
+		if q.CountryID != "" {
+			key += ":" + q.CountryID
+		}
+		var cached Result
+		if value, ok := c.Get(key); ok {
+			err := json.Unmarshal(value, &cached)
+			if err != nil {
+				log.Error(f+"invalid cache value: ", err)
+				cached = Result{}
+			}
+		}
+		res := cached
+		if IsFresh(ctx) {
+			res = Result{}
+		}
+		var missing []Field
+		for _, field := range q.Wanted() {
+			if !res.Has(field) {
+				missing = append(missing, field)
+			}
+		}
+		if len(missing) == 0 {
+			log.Debug(f+"cache hit: ", q.Name)
+			return res, nil
+		}
+		sub := q
+		sub.Fields = missing
+		part, err := next.Enrich(ctx, sub)
+		found := false
+		for _, field := range missing {
+			if part.Has(field) {
+				res.Take(field, part)
+				cached.Take(field, part)
+				found = true
+			}
+		}
+		if !found || IsDryRun(ctx) {
+			return res, err
+		}
+		value, encErr := json.Marshal(cached)
+		if encErr != nil {
+			log.Error(f+"failed to encode cache value: ", encErr)
+			return res, err
+		}
+		c.Set(key, value)
+		return res, err
+	})
+}
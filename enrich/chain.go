@@ -0,0 +1,272 @@
+package enrich
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"people2/config"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The strategies of the provider chains.
+const (
+	// The providers of a field are asked in order until one finds it.
+	StrategyOrdered = "ordered"
+	// All the providers of a field are asked at once and the answers
+	// are combined by the vote.
+	StrategyParallel = "parallel"
+)
+
+// The votes combining the answers of the parallel providers.
+const (
+	// The answer with the highest confidence wins.
+	VoteConfidence = "confidence"
+	// The value with the highest sum of the weighted confidences wins.
+	VoteWeighted = "weighted"
+)
+
+// The providers of every field with the way to combine them.
+type Chains struct {
+	Providers map[Field][]string
+	Strategy  string
+	Vote      string
+	Weights   map[string]float64
+}
+
+// The function reads the chains from the ENRICH_AGE, ENRICH_GENDER and
+// ENRICH_NATIONALITY environment variables (example: "genderize,offline,
+// rules"), ENRICH_STRATEGY, ENRICH_VOTE and ENRICH_WEIGHTS (example:
+// "genderize:1,rules:1.5"). Returns false if no chain is configured.
+func ChainsFromConfig() (*Chains, bool, error) {
+	c := &Chains{
+		Providers: map[Field][]string{},
+		Strategy:  config.String("ENRICH_STRATEGY", StrategyOrdered),
+		Vote:      config.String("ENRICH_VOTE", VoteConfidence),
+		Weights:   map[string]float64{},
+	}
+	for _, field := range Fields {
+		key := "ENRICH_" + strings.ToUpper(string(field))
+		if names := config.List(key, nil); len(names) != 0 {
+			c.Providers[field] = names
+		}
+	}
+	if len(c.Providers) == 0 {
+		return nil, false, nil
+	}
+	for _, item := range config.List("ENRICH_WEIGHTS", nil) {
+		name, value, _ := strings.Cut(item, ":")
+		weight, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid weight %q", item)
+		}
+		c.Weights[strings.TrimSpace(name)] = weight
+	}
+	return c, true, nil
+}
+
+// The function builds the provider of the chains. A field without its
+// own chain is looked up with the "api" provider.
+func NewChains(c *Chains) (Enricher, error) {
+	switch c.Strategy {
+	case StrategyOrdered, StrategyParallel:
+	default:
+		return nil, fmt.Errorf("unknown strategy %q", c.Strategy)
+	}
+	switch c.Vote {
+	case VoteConfidence, VoteWeighted:
+	default:
+		return nil, fmt.Errorf("unknown vote %q", c.Vote)
+	}
+	chains := map[Field][]namedEnricher{}
+	for _, field := range Fields {
+		names := c.Providers[field]
+		if len(names) == 0 {
+			names = []string{"api"}
+		}
+		for _, name := range names {
+			e, ok := Get(name)
+			if !ok {
+				return nil, fmt.Errorf("unknown enricher %q", name)
+			}
+			chains[field] = append(
+				chains[field], namedEnricher{name, Named(name, e)},
+			)
+		}
+	}
+	run := &chainRun{chains: chains, vote: c.Vote, weights: c.Weights}
+	if c.Strategy == StrategyParallel {
+		return Func(run.parallel), nil
+	}
+	return Func(run.ordered), nil
+}
+
+type namedEnricher struct {
+	name string
+	e    Enricher
+}
+
+type chainRun struct {
+	chains  map[Field][]namedEnricher
+	vote    string
+	weights map[string]float64
+}
+
+type answer struct {
+	name string
+	res  Result
+	err  error
+}
+
+// The method asks every provider once for the fields it serves and
+// returns the answers by the provider name.
+func (r *chainRun) ask(
+	ctx context.Context, q Query, plan map[string][]Field,
+) map[string]answer {
+	var mu sync.Mutex
+	answers := map[string]answer{}
+	var tasks sync.WaitGroup
+	for name, fields := range plan {
+		e := r.provider(name)
+		sub := q
+		sub.Fields = fields
+		tasks.Add(1)
+		go func(name string) {
+			defer tasks.Done()
+			res, err := e.Enrich(ctx, sub)
+			mu.Lock()
+			defer mu.Unlock()
+			answers[name] = answer{name, res, err}
+		}(name)
+	}
+	tasks.Wait()
+	return answers
+}
+
+func (r *chainRun) provider(name string) Enricher {
+	for _, chain := range r.chains {
+		for _, item := range chain {
+			if item.name == name {
+				return item.e
+			}
+		}
+	}
+	return nil
+}
+
+// The method asks the next provider of every missing field, the
+// providers shared by several fields are asked once per round.
+func (r *chainRun) ordered(ctx context.Context, q Query) (Result, error) {
+	var res Result
+	errs := map[Field]error{}
+	missing := q.Wanted()
+	for round := 0; len(missing) != 0; round++ {
+		plan := map[string][]Field{}
+		for _, field := range missing {
+			if chain := r.chains[field]; round < len(chain) {
+				plan[chain[round].name] = append(
+					plan[chain[round].name], field,
+				)
+			}
+		}
+		if len(plan) == 0 {
+			break
+		}
+		answers := r.ask(ctx, q, plan)
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		var still []Field
+		for _, field := range missing {
+			chain := r.chains[field]
+			if round >= len(chain) {
+				still = append(still, field)
+				continue
+			}
+			ans := answers[chain[round].name]
+			if ans.res.Has(field) {
+				res.Take(field, ans.res)
+				delete(errs, field)
+				continue
+			}
+			errs[field] = fieldErr(ans.err, field)
+			still = append(still, field)
+		}
+		missing = still
+	}
+	return res, partial(errs)
+}
+
+// The method asks all the providers at once and votes for every field.
+func (r *chainRun) parallel(ctx context.Context, q Query) (Result, error) {
+	var res Result
+	plan := map[string][]Field{}
+	for _, field := range q.Wanted() {
+		for _, item := range r.chains[field] {
+			plan[item.name] = append(plan[item.name], field)
+		}
+	}
+	answers := r.ask(ctx, q, plan)
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+	errs := map[Field]error{}
+	for _, field := range q.Wanted() {
+		var candidates []answer
+		var lastErr error
+		for _, item := range r.chains[field] {
+			ans := answers[item.name]
+			if ans.res.Has(field) {
+				candidates = append(candidates, ans)
+			} else {
+				lastErr = fieldErr(ans.err, field)
+			}
+		}
+		if len(candidates) == 0 {
+			if lastErr == nil {
+				lastErr = errors.New(string(field) + " data not found")
+			}
+			errs[field] = lastErr
+			continue
+		}
+		res.Take(field, r.elect(field, candidates))
+	}
+	return res, partial(errs)
+}
+
+// The method returns the answer that wins the vote for the field. The
+// ties are won by the provider earlier in the chain.
+func (r *chainRun) elect(field Field, candidates []answer) Result {
+	weight := func(name string) float64 {
+		if w, ok := r.weights[name]; ok {
+			return w
+		}
+		return 1
+	}
+	score := func(ans answer) float64 {
+		return weight(ans.name) * ans.res.Confidence(field)
+	}
+	if r.vote == VoteWeighted {
+		totals := map[string]float64{}
+		for _, ans := range candidates {
+			totals[ans.res.Value(field)] += score(ans)
+		}
+		best := candidates[0]
+		for _, ans := range candidates[1:] {
+			value, bestValue := ans.res.Value(field), best.res.Value(field)
+			if totals[value] > totals[bestValue] ||
+				value == bestValue && score(ans) > score(best) {
+				best = ans
+			}
+		}
+		return best.res
+	}
+	best := candidates[0]
+	for _, ans := range candidates[1:] {
+		if score(ans) > score(best) {
+			best = ans
+		}
+	}
+	return best.res
+}
@@ -0,0 +1,275 @@
+package enrich
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"people2/config"
+	"people2/logging"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	log      = logging.Config
+	mu       sync.RWMutex
+	registry = map[string]Enricher{}
+	current  Enricher
+)
+
+// The data used by the providers to look up a person. Fields limits
+// the lookup to the given fields, all of them are looked up if it is
+// empty. CountryID asks the providers that support it to localize the
+// age and gender to the country.
+type Query struct {
+	Name       string
+	Surname    string
+	Patronymic string
+	Fields     []Field
+	CountryID  string
+}
+
+// The data returned by the providers. The probabilities are in the
+// range from 0 to 1, the count is the number of samples behind the
+// gender guess. Nationality is the most probable of the Countries. The
+// sources are the names of the providers that found the fields, the
+// Provenance holds the details of their answers. The countries of the
+// age and gender are set if their answers are localized.
+type Result struct {
+	Age                    uint8
+	Gender                 string
+	GenderProbability      float64
+	GenderCount            int
+	GenderConflict         bool
+	Nationality            string
+	NationalityProbability float64
+	Countries              []Country
+	AgeSource              string
+	GenderSource           string
+	NationalitySource      string
+	Provenance             map[Field]Provenance
+	AgeCountry             string
+	GenderCountry          string
+
+	NationalityNameProbability    float64
+	NationalitySurnameProbability float64
+}
+
+// The origin of the enriched field: the provider, the request URL
+// without secrets and the raw response if the provider is remote, the
+// time of the answer and its confidence.
+type Provenance struct {
+	Provider   string
+	URL        string
+	Response   json.RawMessage
+	Time       time.Time
+	Confidence float64
+}
+
+// The nationality candidate with its probability. The probabilities of
+// the name and the surname are set if they are combined.
+type Country struct {
+	CountryID          string
+	Probability        float64
+	NameProbability    float64
+	SurnameProbability float64
+}
+
+// The interface of the enrichment providers. Enrich returns the age,
+// gender and nationality found for the query, otherwise an error. If
+// only some of the fields are found, they are returned along with a
+// PartialError. The lookups must be aborted when the context is done.
+type Enricher interface {
+	Enrich(ctx context.Context, q Query) (Result, error)
+}
+
+// The adapter to use an ordinary function as an Enricher.
+type Func func(ctx context.Context, q Query) (Result, error)
+
+func (fn Func) Enrich(ctx context.Context, q Query) (Result, error) {
+	return fn(ctx, q)
+}
+
+type dryRunKey struct{}
+
+// The function returns the context of the lookups that must not change
+// anything, such as the previews: their results are not cached.
+func DryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+// The function reports whether the lookups of the context must not
+// change anything.
+func IsDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunKey{}).(bool)
+	return dryRun
+}
+
+type freshKey struct{}
+
+// The function returns the context of the lookups that must not use the
+// cached answers, such as the re-enrichment: the providers are asked
+// again and the cache is updated with their answers.
+func Fresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, freshKey{}, true)
+}
+
+// The function reports whether the lookups of the context must not use
+// the cached answers.
+func IsFresh(ctx context.Context) bool {
+	fresh, _ := ctx.Value(freshKey{}).(bool)
+	return fresh
+}
+
+// The function adds the provider to the registry under the given name,
+// replacing a previously registered one.
+func Register(name string, e Enricher) {
+	mu.Lock()
+	defer mu.Unlock()
+	registry[name] = e
+}
+
+// The function returns the registered provider by its name.
+func Get(name string) (Enricher, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	e, ok := registry[name]
+	return e, ok
+}
+
+// The function selects the providers used by Current. Several names are
+// composed in the given order and combined with the lookup mode and the
+// gender rules, otherwise return an error for an unknown name.
+func Use(names ...string) error {
+	var chain []Enricher
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		e, ok := Get(name)
+		if !ok {
+			return fmt.Errorf("unknown enricher %q", name)
+		}
+		chain = append(chain, Named(name, e))
+	}
+	if len(chain) == 0 {
+		return fmt.Errorf("no enricher selected")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(chain) == 1 {
+		current = wrap(chain[0])
+	} else {
+		current = wrap(Compose(chain...))
+	}
+	return nil
+}
+
+// The function selects the per-field provider chains used by Current.
+// Returns an error for an unknown provider, strategy or vote.
+func UseChains(c *Chains) error {
+	e, err := NewChains(c)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	current = wrap(e)
+	return nil
+}
+
+// The function combines the selected provider with the surname
+// nationality, the lookup mode and the gender rules.
+func wrap(e Enricher) Enricher {
+	e = WithSurname(e, SurnameWeight)
+	if Mode == ModeLocalized {
+		e = Localized(e)
+	}
+	return WithRules(e, GenderRules)
+}
+
+// The function selects the providers from the environment, to be called
+// on the start. The per-field chains are taken from the ENRICH_<FIELD>
+// environment variables, otherwise the providers are taken from the
+// ENRICHER environment variable, or "api" if it is not set. Returns an
+// error for an invalid setting.
+func Configure() error {
+	chains, ok, err := ChainsFromConfig()
+	switch {
+	case err != nil:
+		return fmt.Errorf("invalid enricher chains: %w", err)
+	case ok:
+		return UseChains(chains)
+	default:
+		return Use(config.List("ENRICHER", []string{"api"})...)
+	}
+}
+
+// The function returns the provider selected with Use, UseChains or
+// Configure. Until one is selected, the returned provider fails every
+// lookup.
+func Current() Enricher {
+	mu.RLock()
+	defer mu.RUnlock()
+	if current == nil {
+		return Func(func(ctx context.Context, q Query) (Result, error) {
+			return Result{}, errors.New("no enricher selected")
+		})
+	}
+	return current
+}
+
+// The function combines several providers into one. The providers are
+// called in order and each next one looks up only the fields that are
+// still empty. A PartialError is returned if some field remains empty.
+func Compose(chain ...Enricher) Enricher {
+	return Func(func(ctx context.Context, q Query) (Result, error) {
+		var res Result
+		errs := map[Field]error{}
+		missing := q.Wanted()
+		for _, e := range chain {
+			if err := ctx.Err(); err != nil {
+				return res, err
+			}
+			sub := q
+			sub.Fields = missing
+			part, err := e.Enrich(ctx, sub)
+			var still []Field
+			for _, field := range missing {
+				if part.Has(field) {
+					res.Take(field, part)
+					delete(errs, field)
+					continue
+				}
+				still = append(still, field)
+				if _, ok := errs[field]; !ok {
+					errs[field] = fieldErr(err, field)
+				}
+			}
+			missing = still
+			if len(missing) == 0 {
+				return res, nil
+			}
+		}
+		return res, partial(errs)
+	})
+}
+
+// The function returns the error of the field from the provider error.
+func fieldErr(err error, field Field) error {
+	var partialErr *PartialError
+	switch {
+	case errors.As(err, &partialErr):
+		if fieldErr, ok := partialErr.Errors[field]; ok {
+			return fieldErr
+		}
+		return fmt.Errorf("%s data not found", field)
+	case err != nil:
+		return err
+	default:
+		return fmt.Errorf("%s data not found", field)
+	}
+}
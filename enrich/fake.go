@@ -0,0 +1,47 @@
+package enrich
+
+import (
+	"context"
+	"hash/fnv"
+	"strings"
+)
+
+func init() {
+	Register("fake", Fake{})
+}
+
+var fakeCountries = []string{"RU", "UA", "BY", "KZ", "US", "DE", "PL"}
+
+// The deterministic in-process provider for tests and local runs. The
+// same name always gets the same age, gender and nationality.
+type Fake struct{}
+
+func (Fake) Enrich(ctx context.Context, q Query) (Result, error) {
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+	name := strings.ToLower(q.Name)
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	sum := h.Sum32()
+	gender := "male"
+	if strings.HasSuffix(name, "a") || strings.HasSuffix(name, "я") {
+		gender = "female"
+	}
+	var countries []Country
+	for i, probability := range []float64{0.6, 0.25, 0.1} {
+		countries = append(countries, Country{
+			CountryID:   fakeCountries[(sum+uint32(i))%uint32(len(fakeCountries))],
+			Probability: probability,
+		})
+	}
+	return Result{
+		Age:                    uint8(18 + sum%60),
+		Gender:                 gender,
+		GenderProbability:      0.99,
+		GenderCount:            1000,
+		Nationality:            countries[0].CountryID,
+		NationalityProbability: countries[0].Probability,
+		Countries:              countries,
+	}, nil
+}
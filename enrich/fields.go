@@ -0,0 +1,264 @@
+package enrich
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The enriched field of a person.
+type Field string
+
+const (
+	FieldAge         Field = "age"
+	FieldGender      Field = "gender"
+	FieldNationality Field = "nationality"
+)
+
+// All the enriched fields in the lookup order.
+var Fields = []Field{FieldAge, FieldGender, FieldNationality}
+
+// The error returned along with the partial Result when some of the
+// requested fields were not found.
+type PartialError struct {
+	Errors map[Field]error
+}
+
+func (e *PartialError) Error() string {
+	var errContent []string
+	for _, field := range Fields {
+		if err, ok := e.Errors[field]; ok {
+			errContent = append(errContent, fmt.Sprintf("%s: %v", field, err))
+		}
+	}
+	return strings.Join(errContent, ", ")
+}
+
+// The method exposes the field errors to errors.Is and errors.As.
+func (e *PartialError) Unwrap() []error {
+	var list []error
+	for _, field := range Fields {
+		if err, ok := e.Errors[field]; ok {
+			list = append(list, err)
+		}
+	}
+	return list
+}
+
+// The method returns the failed fields in the lookup order.
+func (e *PartialError) Failed() []Field {
+	var list []Field
+	for field := range e.Errors {
+		list = append(list, field)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return order(list[i]) < order(list[j])
+	})
+	return list
+}
+
+// The function returns nil if there are no field errors, otherwise a
+// PartialError.
+func partial(errs map[Field]error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return &PartialError{Errors: errs}
+}
+
+// The method reports whether the field is one of the enriched fields.
+func (field Field) Valid() bool {
+	return order(field) < len(Fields)
+}
+
+func order(field Field) int {
+	for i, item := range Fields {
+		if item == field {
+			return i
+		}
+	}
+	return len(Fields)
+}
+
+// The method returns the requested fields, all of them by default.
+func (q Query) Wanted() []Field {
+	if len(q.Fields) == 0 {
+		return Fields
+	}
+	return q.Fields
+}
+
+// The method reports whether the field is requested.
+func (q Query) Wants(field Field) bool {
+	for _, item := range q.Wanted() {
+		if item == field {
+			return true
+		}
+	}
+	return false
+}
+
+// The method reports whether the field is filled.
+func (r *Result) Has(field Field) bool {
+	switch field {
+	case FieldAge:
+		return r.Age != 0
+	case FieldGender:
+		return r.Gender != ""
+	case FieldNationality:
+		return r.Nationality != ""
+	}
+	return false
+}
+
+// The method copies the field with its confidence from the other
+// result.
+func (r *Result) Take(field Field, other Result) {
+	switch field {
+	case FieldAge:
+		r.Age = other.Age
+		r.AgeSource = other.AgeSource
+		r.AgeCountry = other.AgeCountry
+	case FieldGender:
+		r.Gender = other.Gender
+		r.GenderSource = other.GenderSource
+		r.GenderCountry = other.GenderCountry
+		r.GenderProbability = other.GenderProbability
+		r.GenderCount = other.GenderCount
+		r.GenderConflict = other.GenderConflict
+	case FieldNationality:
+		r.Nationality = other.Nationality
+		r.NationalitySource = other.NationalitySource
+		r.NationalityProbability = other.NationalityProbability
+		r.Countries = other.Countries
+		r.NationalityNameProbability = other.NationalityNameProbability
+		r.NationalitySurnameProbability = other.NationalitySurnameProbability
+	}
+	if p, ok := other.Provenance[field]; ok {
+		r.trace(field, p)
+	} else {
+		delete(r.Provenance, field)
+	}
+}
+
+// The method returns the value of the field as a string, used to
+// compare the answers of the providers.
+func (r *Result) Value(field Field) string {
+	switch field {
+	case FieldAge:
+		return strconv.Itoa(int(r.Age))
+	case FieldGender:
+		return r.Gender
+	case FieldNationality:
+		return r.Nationality
+	}
+	return ""
+}
+
+// The method returns the confidence of the field from 0 to 1. The age
+// providers report no confidence, so a found age is fully trusted.
+func (r *Result) Confidence(field Field) float64 {
+	switch field {
+	case FieldAge:
+		if r.Age != 0 {
+			return 1
+		}
+	case FieldGender:
+		return r.GenderProbability
+	case FieldNationality:
+		return r.NationalityProbability
+	}
+	return 0
+}
+
+// The method sets the provider that found the field along with its
+// provenance, unless the provenance is already recorded.
+func (r *Result) SetSource(field Field, name string) {
+	switch field {
+	case FieldAge:
+		r.AgeSource = name
+	case FieldGender:
+		r.GenderSource = name
+	case FieldNationality:
+		r.NationalitySource = name
+	}
+	if _, ok := r.Provenance[field]; !ok {
+		r.trace(field, Provenance{
+			Provider:   name,
+			Time:       time.Now(),
+			Confidence: r.Confidence(field),
+		})
+	}
+}
+
+// The method records the provenance of the field.
+func (r *Result) trace(field Field, p Provenance) {
+	if r.Provenance == nil {
+		r.Provenance = map[Field]Provenance{}
+	}
+	r.Provenance[field] = p
+}
+
+// The function wraps the provider to record its name as the source of
+// the found fields that have no source yet.
+func Named(name string, next Enricher) Enricher {
+	return Func(func(ctx context.Context, q Query) (Result, error) {
+		res, err := next.Enrich(ctx, q)
+		for _, field := range Fields {
+			if res.Has(field) && res.source(field) == "" {
+				res.SetSource(field, name)
+			}
+		}
+		return res, err
+	})
+}
+
+// The function limits the provider to the given fields, the other
+// fields are reported as not found without calling it.
+func Only(next Enricher, fields ...Field) Enricher {
+	return Func(func(ctx context.Context, q Query) (Result, error) {
+		errs := map[Field]error{}
+		var wanted []Field
+		for _, field := range q.Wanted() {
+			supported := false
+			for _, item := range fields {
+				supported = supported || item == field
+			}
+			if supported {
+				wanted = append(wanted, field)
+			} else {
+				errs[field] = fmt.Errorf("%s is not supported", field)
+			}
+		}
+		if len(wanted) == 0 {
+			return Result{}, partial(errs)
+		}
+		sub := q
+		sub.Fields = wanted
+		res, err := next.Enrich(ctx, sub)
+		if len(errs) == 0 {
+			return res, err
+		}
+		for _, field := range wanted {
+			if !res.Has(field) {
+				errs[field] = fieldErr(err, field)
+			}
+		}
+		return res, partial(errs)
+	})
+}
+
+func (r *Result) source(field Field) string {
+	switch field {
+	case FieldAge:
+		return r.AgeSource
+	case FieldGender:
+		return r.GenderSource
+	case FieldNationality:
+		return r.NationalitySource
+	}
+	return ""
+}
@@ -0,0 +1,113 @@
+package enrich
+
+import (
+	"context"
+	"errors"
+	"people2/config"
+	"people2/requests"
+)
+
+// The lookup modes.
+const (
+	// The age and gender are looked up worldwide.
+	ModeGlobal = "global"
+	// The nationality is looked up first and the age and gender are
+	// localized to it.
+	ModeLocalized = "localized"
+	// The localized lookups of some fields fell back to the global ones.
+	ModeMixed = "mixed"
+)
+
+// The lookup mode selected with the ENRICH_MODE environment variable:
+// "global" or "localized".
+var Mode = config.String("ENRICH_MODE", ModeGlobal)
+
+// The function wraps the provider to look up the nationality first and
+// then the age and gender localized to the found country, or to the
+// country of the query if the nationality is not requested. The fields
+// that have no localized data are looked up worldwide, the ones that
+// failed transiently are not.
+func Localized(next Enricher) Enricher {
+	return Func(func(ctx context.Context, q Query) (Result, error) {
+		var res Result
+		errs := map[Field]error{}
+		countryID := q.CountryID
+		if q.Wants(FieldNationality) {
+			sub := q
+			sub.Fields = []Field{FieldNationality}
+			sub.CountryID = ""
+			part, err := next.Enrich(ctx, sub)
+			if ctx.Err() != nil {
+				return res, ctx.Err()
+			}
+			if part.Has(FieldNationality) {
+				res.Take(FieldNationality, part)
+				countryID = res.Nationality
+			} else {
+				errs[FieldNationality] = fieldErr(err, FieldNationality)
+			}
+		}
+		missing := without(q.Wanted(), FieldNationality)
+		if countryID != "" {
+			missing = lookupIn(ctx, next, q, countryID, missing, &res, errs)
+		}
+		if len(missing) != 0 && ctx.Err() == nil {
+			lookupIn(ctx, next, q, "", missing, &res, errs)
+		}
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		return res, partial(errs)
+	})
+}
+
+// The function looks up the fields in the country and takes the found
+// ones into the result. Returns the fields that have no data, the
+// transient failures are only recorded.
+func lookupIn(
+	ctx context.Context, next Enricher, q Query, countryID string,
+	fields []Field, res *Result, errs map[Field]error,
+) []Field {
+	if len(fields) == 0 {
+		return nil
+	}
+	sub := q
+	sub.Fields = fields
+	sub.CountryID = countryID
+	part, err := next.Enrich(ctx, sub)
+	var missing []Field
+	for _, field := range fields {
+		if part.Has(field) {
+			res.Take(field, part)
+			delete(errs, field)
+			continue
+		}
+		errs[field] = fieldErr(err, field)
+		if !transient(errs[field]) {
+			missing = append(missing, field)
+		}
+	}
+	return missing
+}
+
+// The function reports whether the lookup failed for a reason the
+// other lookups of the provider would fail for too: it is unavailable,
+// over its quota or limit, or fails after the retries.
+func transient(err error) bool {
+	return requests.Retryable(err) ||
+		errors.As(err, new(*requests.BreakerError)) ||
+		errors.As(err, new(*requests.QuotaError)) ||
+		errors.As(err, new(*requests.LimitError))
+}
+
+// The function returns the lookup mode by the countries the age and
+// gender were localized to.
+func ModeOf(ageCountry, genderCountry string) string {
+	switch {
+	case ageCountry != "" && genderCountry != "":
+		return ModeLocalized
+	case ageCountry != "" || genderCountry != "":
+		return ModeMixed
+	}
+	return ModeGlobal
+}
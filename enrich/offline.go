@@ -0,0 +1,87 @@
+package enrich
+
+import (
+	"context"
+	"errors"
+	"os"
+	"people2/config"
+	"people2/namestats"
+	"sync"
+	"time"
+)
+
+// The path of the name statistics used by the offline provider.
+var OfflineDataset = config.String("OFFLINE_DATASET", "data/namestats.csv")
+
+func init() {
+	Register("offline", &Offline{Path: OfflineDataset})
+}
+
+// The provider that answers from the local name statistics without
+// calling the external APIs. The dataset is reloaded when its file is
+// changed by the namestats command.
+type Offline struct {
+	Path string
+
+	mu      sync.Mutex
+	data    namestats.Dataset
+	modTime time.Time
+}
+
+func (o *Offline) Enrich(ctx context.Context, q Query) (Result, error) {
+	var res Result
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+	data, err := o.dataset()
+	if err != nil {
+		return res, err
+	}
+	errs := map[Field]error{}
+	record, ok := data[namestats.Normalize(q.Name)]
+	for _, field := range q.Wanted() {
+		switch {
+		case !ok:
+			errs[field] = errors.New("name not found in offline dataset")
+		case field == FieldAge && record.Age != 0:
+			res.Age = record.Age
+		case field == FieldGender && record.Gender != "":
+			res.Gender = record.Gender
+			res.GenderProbability = record.GenderProbability
+			res.GenderCount = record.GenderCount
+		case field == FieldNationality && len(record.Countries) != 0:
+			for _, country := range record.Countries {
+				res.Countries = append(res.Countries, Country{
+					CountryID:   country.CountryID,
+					Probability: country.Probability,
+				})
+			}
+			res.Nationality = res.Countries[0].CountryID
+			res.NationalityProbability = res.Countries[0].Probability
+		default:
+			errs[field] = errors.New(string(field) + " data not found")
+		}
+	}
+	return res, partial(errs)
+}
+
+// The method returns the dataset, reading the file again if it was
+// modified since the last read.
+func (o *Offline) dataset() (namestats.Dataset, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	info, err := os.Stat(o.Path)
+	if err != nil {
+		return nil, err
+	}
+	if o.data != nil && info.ModTime().Equal(o.modTime) {
+		return o.data, nil
+	}
+	data, err := namestats.Load(o.Path)
+	if err != nil {
+		return nil, err
+	}
+	o.data = data
+	o.modTime = info.ModTime()
+	return data, nil
+}
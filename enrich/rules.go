@@ -0,0 +1,119 @@
+package enrich
+
+import (
+	"context"
+	"errors"
+	"people2/config"
+	"people2/morph"
+)
+
+// The use of the patronymic and surname rules for the gender: "off",
+// "primary" (the rules are asked first) or "fallback" (the rules fill
+// the gender the providers did not find).
+var GenderRules = config.String("GENDER_RULES", "fallback")
+
+func init() {
+	Register("rules", Rules{})
+}
+
+// The provider that infers the gender from the endings of the
+// patronymic and the surname. The other fields are never found.
+type Rules struct{}
+
+func (Rules) Enrich(ctx context.Context, q Query) (Result, error) {
+	var res Result
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+	errs := map[Field]error{}
+	for _, field := range q.Wanted() {
+		if field != FieldGender {
+			errs[field] = errors.New("not supported by rules")
+			continue
+		}
+		inference, ok := morph.Gender(q.Surname, q.Patronymic)
+		if !ok {
+			errs[field] = errors.New("no known name endings")
+			continue
+		}
+		res.Gender = inference.Gender
+		res.GenderProbability = inference.Confidence
+	}
+	return res, partial(errs)
+}
+
+// The function combines the provider with the rules in the given mode.
+// In the "fallback" mode the gender that disagrees with the patronymic
+// is marked with GenderConflict.
+func WithRules(next Enricher, mode string) Enricher {
+	switch mode {
+	case "primary":
+		return Func(func(ctx context.Context, q Query) (Result, error) {
+			inference, ok := morph.Gender(q.Surname, q.Patronymic)
+			if !ok || !q.Wants(FieldGender) {
+				return next.Enrich(ctx, q)
+			}
+			var res Result
+			var err error
+			sub := q
+			sub.Fields = without(q.Wanted(), FieldGender)
+			if len(sub.Fields) != 0 {
+				res, err = next.Enrich(ctx, sub)
+			}
+			res.Gender = inference.Gender
+			res.GenderProbability = inference.Confidence
+			res.GenderCount = 0
+			res.SetSource(FieldGender, "rules")
+			return res, err
+		})
+	case "fallback":
+		return Func(func(ctx context.Context, q Query) (Result, error) {
+			res, err := next.Enrich(ctx, q)
+			if !q.Wants(FieldGender) || ctx.Err() != nil {
+				return res, err
+			}
+			if res.Has(FieldGender) {
+				inference, ok := morph.Patronymic(q.Patronymic)
+				res.GenderConflict = ok && inference.Gender != res.Gender
+				return res, err
+			}
+			inference, ok := morph.Gender(q.Surname, q.Patronymic)
+			if !ok {
+				return res, err
+			}
+			res.Gender = inference.Gender
+			res.GenderProbability = inference.Confidence
+			res.GenderCount = 0
+			res.SetSource(FieldGender, "rules")
+			return res, withoutErr(err, FieldGender)
+		})
+	default:
+		return next
+	}
+}
+
+// The function returns the fields except the given one.
+func without(fields []Field, field Field) []Field {
+	var list []Field
+	for _, item := range fields {
+		if item != field {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// The function removes the field from the PartialError.
+func withoutErr(err error, field Field) error {
+	var partialErr *PartialError
+	if !errors.As(err, &partialErr) {
+		return err
+	}
+	errs := map[Field]error{}
+	for item, fieldErr := range partialErr.Errors {
+		if item != field {
+			errs[item] = fieldErr
+		}
+	}
+	return partial(errs)
+}
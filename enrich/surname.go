@@ -0,0 +1,132 @@
+package enrich
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"people2/config"
+	"sort"
+)
+
+// The weight of the surname in the combined nationality, from 0 (the
+// surname is not used) to 1 (only the surname is used). The values out
+// of the range are clamped to it.
+var SurnameWeight = clamp(config.Float("NATIONALITY_SURNAME_WEIGHT", 0.5))
+
+// The function wraps the provider to look up the nationality of both
+// the name and the surname and to combine the two distributions with
+// the given surname weight, clamped to the range from 0 to 1. If only
+// one of them is found, it is used alone.
+func WithSurname(next Enricher, weight float64) Enricher {
+	weight = clamp(weight)
+	return Func(func(ctx context.Context, q Query) (Result, error) {
+		if !q.Wants(FieldNationality) || q.Surname == "" || weight <= 0 {
+			res, err := next.Enrich(ctx, q)
+			return nameOnly(res), err
+		}
+		var surname Result
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			sub := Query{
+				Name:   q.Surname,
+				Fields: []Field{FieldNationality},
+			}
+			surname, _ = next.Enrich(ctx, sub)
+		}()
+		res, err := next.Enrich(ctx, q)
+		<-done
+		if ctx.Err() != nil {
+			return res, ctx.Err()
+		}
+		if !surname.Has(FieldNationality) {
+			return nameOnly(res), err
+		}
+		if !res.Has(FieldNationality) {
+			surname.Countries = Combine(nil, ranking(surname), 1)
+			surname.Nationality = surname.Countries[0].CountryID
+			surname.NationalityProbability = surname.Countries[0].Probability
+			surname.NationalitySurnameProbability = surname.NationalityProbability
+			res.Take(FieldNationality, surname)
+			return res, withoutErr(err, FieldNationality)
+		}
+		combined := Combine(ranking(res), ranking(surname), weight)
+		provenance, traced := res.Provenance[FieldNationality]
+		res.Countries = combined
+		res.Nationality = combined[0].CountryID
+		res.NationalityProbability = combined[0].Probability
+		res.NationalityNameProbability = combined[0].NameProbability
+		res.NationalitySurnameProbability = combined[0].SurnameProbability
+		if traced {
+			// The raw answers of both lookups are kept
+			response, _ := json.Marshal(map[string]json.RawMessage{
+				"name":    provenance.Response,
+				"surname": surname.Provenance[FieldNationality].Response,
+			})
+			provenance.Response = response
+			provenance.Confidence = res.NationalityProbability
+			res.trace(FieldNationality, provenance)
+		}
+		return res, err
+	})
+}
+
+// The function combines the nationality distributions of the name and
+// the surname into one ranking, the most probable first. The combined
+// probability is the weighted sum of the probabilities of the sources.
+func Combine(name, surname []Country, weight float64) []Country {
+	index := map[string]*Country{}
+	var list []*Country
+	get := func(countryID string) *Country {
+		country, ok := index[countryID]
+		if !ok {
+			country = &Country{CountryID: countryID}
+			index[countryID] = country
+			list = append(list, country)
+		}
+		return country
+	}
+	for _, item := range name {
+		get(item.CountryID).NameProbability = item.Probability
+	}
+	for _, item := range surname {
+		get(item.CountryID).SurnameProbability = item.Probability
+	}
+	combined := make([]Country, 0, len(list))
+	for _, country := range list {
+		country.Probability = (1-weight)*country.NameProbability +
+			weight*country.SurnameProbability
+		combined = append(combined, *country)
+	}
+	sort.SliceStable(combined, func(i, j int) bool {
+		return combined[i].Probability > combined[j].Probability
+	})
+	return combined
+}
+
+// The function marks the nationality of the result as found by the
+// name alone.
+func nameOnly(res Result) Result {
+	if res.Has(FieldNationality) {
+		res.Countries = Combine(ranking(res), nil, 0)
+		res.NationalityNameProbability = res.NationalityProbability
+	}
+	return res
+}
+
+// The function returns the nationality candidates of the result, the
+// nationality alone if the provider ranks no candidates.
+func ranking(res Result) []Country {
+	if len(res.Countries) != 0 {
+		return res.Countries
+	}
+	return []Country{{
+		CountryID:   res.Nationality,
+		Probability: res.NationalityProbability,
+	}}
+}
+
+// The function limits the weight to the range from 0 to 1.
+func clamp(weight float64) float64 {
+	return math.Max(0, math.Min(1, weight))
+}
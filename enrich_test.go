@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"people2/cache"
+	"people2/enrich"
+	"people2/requests"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing the composition of the providers in the enrich package.
+func TestComposePartial(t *testing.T) {
+	var asked []enrich.Field
+	first := enrich.Func(
+		func(ctx context.Context, q enrich.Query) (enrich.Result, error) {
+			return enrich.Result{Age: 42}, &enrich.PartialError{
+				Errors: map[enrich.Field]error{
+					enrich.FieldGender:      errors.New("down"),
+					enrich.FieldNationality: errors.New("down"),
+				},
+			}
+		},
+	)
+	second := enrich.Func(
+		func(ctx context.Context, q enrich.Query) (enrich.Result, error) {
+			asked = q.Wanted()
+			return enrich.Result{Age: 30, Gender: "male"}, nil
+		},
+	)
+
+	res, err := enrich.Compose(first, second).Enrich(
+		context.Background(), enrich.Query{Name: "Ivan"},
+	)
+	var partialErr *enrich.PartialError
+	assert.True(t, errors.As(err, &partialErr))
+	assert.Equal(t, []enrich.Field{enrich.FieldNationality}, partialErr.Failed())
+	assert.Equal(
+		t,
+		[]enrich.Field{enrich.FieldGender, enrich.FieldNationality},
+		asked,
+	)
+	assert.Equal(t, uint8(42), res.Age)
+	assert.Equal(t, "male", res.Gender)
+}
+
+// Testing the offline provider of the enrich package.
+func TestOfflineProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "namestats.csv")
+	err := os.WriteFile(path, []byte(
+		"name,age,gender,gender_probability,gender_count,countries\n"+
+			"Ivan,45,male,0.99,1000,UA:0.2|RU:0.6\n"+
+			"Anna,,female,0.98,2000,\n",
+	), 0o644)
+	assert.NoError(t, err)
+	offline := &enrich.Offline{Path: path}
+
+	res, err := offline.Enrich(
+		context.Background(), enrich.Query{Name: "IVAN"},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(45), res.Age)
+	assert.Equal(t, "male", res.Gender)
+	assert.Equal(t, "RU", res.Nationality)
+	assert.Len(t, res.Countries, 2)
+
+	res, err = offline.Enrich(
+		context.Background(), enrich.Query{Name: "Anna"},
+	)
+	var partialErr *enrich.PartialError
+	assert.True(t, errors.As(err, &partialErr))
+	assert.Equal(
+		t,
+		[]enrich.Field{enrich.FieldAge, enrich.FieldNationality},
+		partialErr.Failed(),
+	)
+	assert.Equal(t, "female", res.Gender)
+}
+
+// Testing the per-field provider chains in the enrich package.
+func TestProviderChains(t *testing.T) {
+	answer := func(gender string, probability float64) enrich.Enricher {
+		return enrich.Func(
+			func(ctx context.Context, q enrich.Query) (enrich.Result, error) {
+				return enrich.Result{
+					Gender:            gender,
+					GenderProbability: probability,
+				}, nil
+			},
+		)
+	}
+	enrich.Register("test-empty", answer("", 0))
+	enrich.Register("test-male", answer("male", 0.6))
+	enrich.Register("test-female", answer("female", 0.5))
+	enrich.Register("test-female2", answer("female", 0.4))
+	query := enrich.Query{Name: "Sasha", Fields: []enrich.Field{enrich.FieldGender}}
+
+	// Ordered: the first provider with an answer wins
+	e, err := enrich.NewChains(&enrich.Chains{
+		Providers: map[enrich.Field][]string{
+			enrich.FieldGender: {"test-empty", "test-female", "test-male"},
+		},
+		Strategy: enrich.StrategyOrdered,
+		Vote:     enrich.VoteConfidence,
+	})
+	assert.NoError(t, err)
+	res, err := e.Enrich(context.Background(), query)
+	assert.NoError(t, err)
+	assert.Equal(t, "female", res.Gender)
+	assert.Equal(t, "test-female", res.GenderSource)
+
+	// Parallel: the highest confidence wins
+	providers := map[enrich.Field][]string{
+		enrich.FieldGender: {"test-female", "test-male", "test-female2"},
+	}
+	e, err = enrich.NewChains(&enrich.Chains{
+		Providers: providers,
+		Strategy:  enrich.StrategyParallel,
+		Vote:      enrich.VoteConfidence,
+	})
+	assert.NoError(t, err)
+	res, err = e.Enrich(context.Background(), query)
+	assert.NoError(t, err)
+	assert.Equal(t, "test-male", res.GenderSource)
+
+	// Parallel: the weighted sum of the agreeing answers wins
+	e, err = enrich.NewChains(&enrich.Chains{
+		Providers: providers,
+		Strategy:  enrich.StrategyParallel,
+		Vote:      enrich.VoteWeighted,
+		Weights:   map[string]float64{"test-male": 1.2},
+	})
+	assert.NoError(t, err)
+	res, err = e.Enrich(context.Background(), query)
+	assert.NoError(t, err)
+	assert.Equal(t, "female", res.Gender)
+	assert.Equal(t, "test-female", res.GenderSource)
+
+	// Unknown providers are rejected
+	_, err = enrich.NewChains(&enrich.Chains{
+		Providers: map[enrich.Field][]string{enrich.FieldAge: {"missing"}},
+		Strategy:  enrich.StrategyOrdered,
+		Vote:      enrich.VoteConfidence,
+	})
+	assert.Error(t, err)
+}
+
+// Testing the two-stage localized lookup in the enrich package.
+func TestLocalizedLookup(t *testing.T) {
+	var countries []string
+	next := enrich.Func(
+		func(ctx context.Context, q enrich.Query) (enrich.Result, error) {
+			countries = append(countries, q.CountryID)
+			var res enrich.Result
+			errs := map[enrich.Field]error{}
+			for _, field := range q.Wanted() {
+				switch {
+				case field == enrich.FieldNationality:
+					res.Nationality = "RU"
+				case field == enrich.FieldAge && q.CountryID == "RU":
+					res.Age = 35
+					res.AgeCountry = q.CountryID
+				case field == enrich.FieldGender && q.CountryID == "":
+					res.Gender = "male"
+				default:
+					errs[field] = errors.New("no data")
+				}
+			}
+			if len(errs) != 0 {
+				return res, &enrich.PartialError{Errors: errs}
+			}
+			return res, nil
+		},
+	)
+
+	res, err := enrich.Localized(next).Enrich(
+		context.Background(), enrich.Query{Name: "Ivan"},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"", "RU", ""}, countries)
+	assert.Equal(t, uint8(35), res.Age)
+	assert.Equal(t, "RU", res.AgeCountry)
+	assert.Equal(t, "male", res.Gender)
+	assert.Equal(t, "", res.GenderCountry)
+	assert.Equal(
+		t, enrich.ModeMixed, enrich.ModeOf(res.AgeCountry, res.GenderCountry),
+	)
+
+	// The transient failure of the localized lookup is not retried
+	// worldwide
+	countries = nil
+	unavailable := enrich.Func(
+		func(ctx context.Context, q enrich.Query) (enrich.Result, error) {
+			countries = append(countries, q.CountryID)
+			if q.CountryID == "" {
+				return enrich.Result{Nationality: "RU"}, nil
+			}
+			return enrich.Result{}, &requests.StatusError{Code: 503}
+		},
+	)
+	_, err = enrich.Localized(unavailable).Enrich(
+		context.Background(), enrich.Query{Name: "Ivan"},
+	)
+	var partialErr *enrich.PartialError
+	assert.True(t, errors.As(err, &partialErr))
+	assert.Equal(t, []string{"", "RU"}, countries)
+	assert.ElementsMatch(
+		t, []enrich.Field{enrich.FieldAge, enrich.FieldGender},
+		partialErr.Failed(),
+	)
+}
+
+// Testing the nationality of the name and the surname in the enrich
+// package.
+func TestSurnameNationality(t *testing.T) {
+	next := enrich.Func(
+		func(ctx context.Context, q enrich.Query) (enrich.Result, error) {
+			countries := map[string][]enrich.Country{
+				"Ivan": {
+					{CountryID: "UA", Probability: 0.4},
+					{CountryID: "RU", Probability: 0.3},
+				},
+				"Kowalski": {
+					{CountryID: "PL", Probability: 0.9},
+					{CountryID: "RU", Probability: 0.05},
+				},
+			}[q.Name]
+			return enrich.Result{
+				Nationality:            countries[0].CountryID,
+				NationalityProbability: countries[0].Probability,
+				Countries:              countries,
+			}, nil
+		},
+	)
+
+	res, err := enrich.WithSurname(next, 0.5).Enrich(
+		context.Background(),
+		enrich.Query{Name: "Ivan", Surname: "Kowalski"},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "PL", res.Nationality)
+	assert.InDelta(t, 0.45, res.NationalityProbability, 1e-9)
+	assert.Zero(t, res.NationalityNameProbability)
+	assert.InDelta(t, 0.9, res.NationalitySurnameProbability, 1e-9)
+	assert.Equal(t, "UA", res.Countries[1].CountryID)
+	assert.Equal(t, "RU", res.Countries[2].CountryID)
+	assert.InDelta(t, 0.175, res.Countries[2].Probability, 1e-9)
+	assert.InDelta(t, 0.3, res.Countries[2].NameProbability, 1e-9)
+
+	// The weight above 1 is clamped, only the surname is used
+	res, err = enrich.WithSurname(next, 1.5).Enrich(
+		context.Background(),
+		enrich.Query{Name: "Ivan", Surname: "Kowalski"},
+	)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.9, res.NationalityProbability, 1e-9)
+	for _, country := range res.Countries {
+		assert.GreaterOrEqual(t, country.Probability, 0.0)
+	}
+}
+
+// Testing the fresh lookups bypassing the cache in the enrich package.
+func TestCachedFresh(t *testing.T) {
+	age := uint8(30)
+	calls := 0
+	next := enrich.Func(
+		func(ctx context.Context, q enrich.Query) (enrich.Result, error) {
+			calls++
+			return enrich.Result{
+				Age: age, Gender: "male", Nationality: "RU",
+			}, nil
+		},
+	)
+	cached := enrich.Cached(next, cache.New(time.Hour, cache.NewLRU(10)))
+	q := enrich.Query{Name: "Ivan"}
+
+	res, err := cached.Enrich(context.Background(), q)
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(30), res.Age)
+	age = 40
+	res, err = cached.Enrich(context.Background(), q)
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(30), res.Age)
+	assert.Equal(t, 1, calls)
+
+	// The fresh lookup asks the provider again and updates the cache
+	fresh := q
+	fresh.Fields = []enrich.Field{enrich.FieldAge}
+	res, err = cached.Enrich(enrich.Fresh(context.Background()), fresh)
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(40), res.Age)
+	assert.Equal(t, 2, calls)
+	res, err = cached.Enrich(context.Background(), q)
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(40), res.Age)
+	assert.Equal(t, "male", res.Gender)
+	assert.Equal(t, 2, calls)
+}
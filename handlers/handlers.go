@@ -1,30 +1,51 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"people2/cache"
+	"people2/config"
 	db "people2/database"
+	"people2/enrich"
+	"people2/jobs"
 	"people2/logging"
 	"people2/models"
+	"people2/pipeline"
+	"people2/requests"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 var (
 	log = logging.Config
+	// Create saves the jobs for the workers instead of the entries.
+	CreateAsync = config.Bool("CREATE_ASYNC", false)
+	// The maximal number of the messages previewed at once.
+	PreviewBatchSize = config.Int("PREVIEW_BATCH_SIZE", 50)
 )
 
 // This API handler processes, checks, enriches and saves correct
 // incoming messages to the database. Return a JSON success
-// message or an error with its cause.
+// message or an error with its cause. In the asynchronous mode, enabled
+// by CREATE_ASYNC or the "async" parameter, the checked message is
+// saved as a job and its ID is returned with the 202 status.
 func Create(c *gin.Context) {
 	f := logging.F()
 	var dataMsg models.FullName
 	if err := c.ShouldBind(&dataMsg); err != nil {
 		log.Debug(f+"parsing failed: ", err)
-		c.JSON(400, gin.H{"error": "Invalid API query"})
+		respondInvalid(c, 400, models.Invalid(
+			"body", models.CodeInvalid, "Invalid API query",
+		))
 		return
 	}
 	log.WithFields(logrus.Fields{
@@ -32,99 +53,187 @@ func Create(c *gin.Context) {
 		"Surname":    dataMsg.Surname,
 		"Patronymic": dataMsg.Patronymic,
 	}).Debug(f + "dataMsg")
-	result := dataMsg.IsValid()
-	if result != "" {
-		log.Debug(f+"invalid message: ", result)
-		dataMsg.Error = result
-		c.JSON(422, gin.H{"error": dataMsg.Error})
+	async, err := strconv.ParseBool(
+		c.DefaultQuery("async", strconv.FormatBool(CreateAsync)),
+	)
+	if err != nil {
+		log.Debug(f+"invalid async parameter: ", err)
+		respondInvalid(c, 400, models.Invalid(
+			"async", models.CodeInvalid, "Invalid async parameter",
+		))
 		return
 	}
-	entry := models.Entry{
-		Name:       dataMsg.Name,
-		Surname:    dataMsg.Surname,
-		Patronymic: dataMsg.Patronymic,
+	if async {
+		createAsync(c, dataMsg)
+		return
 	}
-	err := entry.Enrich(entry.Name)
+	entry, err := pipeline.Process(c.Request.Context(), dataMsg)
 	if err != nil {
-		log.Error(f+"failed to enrich data from API: ", err)
-		dataMsg.Error = fmt.Sprintf("Failed to enrich data from API: %v", err)
-		c.JSON(500, gin.H{"error": dataMsg.Error})
+		respondError(c, err)
 		return
 	}
-	log.WithFields(logrus.Fields{
-		"ID":          entry.ID,
-		"Name":        entry.Name,
-		"Surname":     entry.Surname,
-		"Patronymic":  entry.Patronymic,
-		"Age":         entry.Age,
-		"Gender":      entry.Gender,
-		"Nationality": entry.Nationality,
-	}).Debug(f + "entry")
-	err = entry.IsValid()
+	if pending := entry.Pending(); len(pending) != 0 {
+		c.JSON(200, gin.H{"message": "Success", "pending": pending})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Success"})
+}
+
+// The function checks the message and saves it as a job for the
+// workers. Return the job ID or an error with its cause.
+func createAsync(c *gin.Context, dataMsg models.FullName) {
+	f := logging.F()
+	// The message is normalized again by the worker, the job keeps the
+	// original spelling
+	normalized := dataMsg
+	normalized.Normalize()
+	err := normalized.IsValid()
 	if err != nil {
-		c.JSON(422, gin.H{"error": fmt.Sprintf("Filling errors: %v", err)})
+		log.Debug(f+"invalid message: ", err)
+		respondError(c, err)
 		return
 	}
-	err = db.C.Create(&entry).Error
+	job, err := jobs.Submit(dataMsg)
 	if err != nil {
-		log.Error(f+"failed to create entry: ", err)
-		c.JSON(500, gin.H{"error": "Failed to create entry"})
+		log.Error(f+"failed to create job: ", err)
+		c.JSON(500, gin.H{"error": "Failed to create job"})
 		return
 	}
-	c.JSON(200, gin.H{"message": "Success"})
+	c.Header("Location", "/api/jobs/"+job.ID)
+	c.JSON(202, gin.H{"message": "Accepted", "job": job.ID})
+}
+
+// This API handler returns the status of the asynchronous creation by
+// the job ID: the ID of the created entry or the cause of the failure.
+func Job(c *gin.Context) {
+	f := logging.F()
+	id := c.Param("id")
+	job, err := jobs.Get(id)
+	if err != nil {
+		log.Debug(f+"job not found: ", err)
+		c.JSON(
+			404,
+			gin.H{"message": fmt.Sprintf(`Job "%v" does not exist`, id)},
+		)
+		return
+	}
+	c.JSON(200, gin.H{"job": job})
+}
+
+// The function writes the pipeline error with its status code. The
+// validation errors are written with the 422 status code.
+func respondError(c *gin.Context, err error) {
+	var perr *pipeline.Error
+	var errs models.ValidationErrors
+	switch {
+	case errors.As(err, &perr):
+	case errors.As(err, &errs):
+		respondInvalid(c, 422, errs)
+		return
+	default:
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if perr.Code == 499 {
+		c.AbortWithStatus(perr.Code)
+		return
+	}
+	if !perr.RetryAfter.IsZero() {
+		c.Header("Retry-After", retryAfter(perr.RetryAfter))
+	}
+	if len(perr.Errors) != 0 {
+		c.JSON(perr.Code, gin.H{"error": perr.Message, "errors": perr.Errors})
+		return
+	}
+	c.JSON(perr.Code, gin.H{"error": perr.Message})
+}
+
+// The function writes the validation errors with the status code: the
+// invalid fields with their codes and the message joining them.
+func respondInvalid(c *gin.Context, code int, errs models.ValidationErrors) {
+	c.JSON(code, gin.H{"error": errs.Error(), "errors": errs})
 }
 
 // This API handler reads filtering parameters and get data from the
-// database. Return a JSON message with data or an error with its
-// cause.
+// database. The "country" and "probability" parameters match the
+// entries with any nationality candidate above the given probability,
+// the "candidates" parameter adds the candidates to the entries. Return
+// a JSON message with data or an error with its cause.
 func Read(c *gin.Context) {
 	f := logging.F()
 	pageSize := c.DefaultQuery("size", "10")
 	pageNum := c.DefaultQuery("page", "1")
 	filterCol := c.Query("col")
 	filterData := c.Query("data")
+	country := strings.ToUpper(c.Query("country"))
+	probability := c.DefaultQuery("probability", "0")
+	candidates := c.Query("candidates") == "true"
 	log.WithFields(logrus.Fields{
-		"Size":   pageSize,
-		"Num":    pageNum,
-		"Column": filterCol,
-		"Data":   filterData,
+		"Size":        pageSize,
+		"Num":         pageNum,
+		"Column":      filterCol,
+		"Data":        filterData,
+		"Country":     country,
+		"Probability": probability,
 	}).Debug(f + "GET filters")
 	switch {
 	case filterCol != "" && filterData == "":
-		fallthrough
+		respondInvalid(c, 400, models.Invalid(
+			"data", models.CodeRequired, `Fill in both "col" and "data"`,
+		))
+		return
 	case filterCol == "" && filterData != "":
-		c.JSON(400, gin.H{"error": `Fill in both "col" and "data"`})
+		respondInvalid(c, 400, models.Invalid(
+			"col", models.CodeRequired, `Fill in both "col" and "data"`,
+		))
 		return
 	}
 	intSize, err := strconv.Atoi(pageSize)
 	if err != nil {
 		log.Debug(f+"invalid page size: ", err)
-		c.JSON(400, gin.H{"error": "Invalid size parameter"})
+		respondInvalid(c, 400, models.Invalid(
+			"size", models.CodeInvalid, "Invalid size parameter",
+		))
 		return
 	}
 	intPage, err := strconv.Atoi(pageNum)
 	if err != nil {
 		log.Debug(f+"invalid page number: ", err)
-		c.JSON(400, gin.H{"error": "Invalid page parameter"})
+		respondInvalid(c, 400, models.Invalid(
+			"page", models.CodeInvalid, "Invalid page parameter",
+		))
+		return
+	}
+	minProbability, err := strconv.ParseFloat(probability, 64)
+	if err != nil {
+		log.Debug(f+"invalid probability: ", err)
+		respondInvalid(c, 400, models.Invalid(
+			"probability", models.CodeInvalid, "Invalid probability parameter",
+		))
 		return
 	}
 	offset := (intPage - 1) * intSize
 	var entries []models.Entry
-	switch {
-	case filterCol != "" && filterData != "":
-		err = db.C.Model(&models.Entry{}).
-			Limit(intSize).
-			Offset(offset).
-			Where(filterCol+" LIKE ?", "%"+filterData+"%").
-			Find(&entries).
-			Error
-	default:
-		err = db.C.Model(&models.Entry{}).
-			Limit(intSize).
-			Offset(offset).
-			Find(&entries).
-			Error
+	query := db.C.Model(&models.Entry{}).
+		Limit(intSize).
+		Offset(offset)
+	if filterCol != "" && filterData != "" {
+		query = query.Where(filterCol+" LIKE ?", "%"+filterData+"%")
 	}
+	if country != "" {
+		query = query.Where(
+			`EXISTS (SELECT 1 FROM nationality_candidates nc
+			WHERE nc.entry_id = entries.id
+			AND nc.country_id = ? AND nc.probability >= ?)`,
+			country, minProbability,
+		)
+	}
+	if candidates {
+		query = query.Preload("Candidates", func(tx *gorm.DB) *gorm.DB {
+			return tx.Order("probability DESC")
+		})
+	}
+	err = query.Find(&entries).Error
 	if err != nil {
 		log.Error(f+"request to the database failed: ", err)
 		c.JSON(500, gin.H{"error": "Request failed"})
@@ -140,7 +249,9 @@ func Update(c *gin.Context) {
 	var updEntry models.Entry
 	if err := c.ShouldBind(&updEntry); err != nil {
 		log.Debug(f+"parsing failed: ", err)
-		c.JSON(400, gin.H{"error": "Invalid API query"})
+		respondInvalid(c, 400, models.Invalid(
+			"body", models.CodeInvalid, "Invalid API query",
+		))
 		return
 	}
 	log.WithFields(logrus.Fields{
@@ -152,22 +263,20 @@ func Update(c *gin.Context) {
 		"Gender":      updEntry.Gender,
 		"Nationality": updEntry.Nationality,
 	}).Debug(f + "updEntry")
+	// The update sets every field, so none of them may be left pending
+	// whatever statuses the client sent
+	for _, field := range enrich.Fields {
+		updEntry.SetStatus(field, models.StatusOK)
+	}
+	updEntry.Normalize()
 	err := updEntry.IsValid()
 	if err != nil {
-		c.JSON(422, gin.H{"error": fmt.Sprintf("Filling errors: %v", err)})
+		log.Debug(f+"invalid entry: ", err)
+		respondError(c, err)
 		return
 	}
-	err = db.C.Model(&models.Entry{}).
-		Where("id = ?", updEntry.ID).
-		Updates(map[string]interface{}{
-			"name":        updEntry.Name,
-			"surname":     updEntry.Surname,
-			"patronymic":  updEntry.Patronymic,
-			"age":         updEntry.Age,
-			"gender":      updEntry.Gender,
-			"nationality": updEntry.Nationality,
-		}).
-		Error
+	var entry models.Entry
+	err = db.C.First(&entry, "id = ?", updEntry.ID).Error
 	if err != nil {
 		c.JSON(
 			404,
@@ -178,9 +287,88 @@ func Update(c *gin.Context) {
 		)
 		return
 	}
+	entry.Rename(&updEntry)
+	// The changed fields are set by hand and kept by the enrichment
+	var changed []enrich.Field
+	for _, field := range enrich.Fields {
+		if updEntry.Value(field) != entry.Value(field) {
+			changed = append(changed, field)
+		}
+	}
+	entry.Age = updEntry.Age
+	entry.Gender = updEntry.Gender
+	entry.Nationality = updEntry.Nationality
+	for _, field := range changed {
+		entry.SetManual(field)
+	}
+	err = db.C.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.Entry{}).
+			Where("id = ?", entry.ID).
+			Updates(map[string]interface{}{
+				"name":               entry.Name,
+				"surname":            entry.Surname,
+				"patronymic":         entry.Patronymic,
+				"mixed_script":       entry.MixedScript,
+				"age_status":         models.StatusOK,
+				"gender_status":      models.StatusOK,
+				"nationality_status": models.StatusOK,
+			}).
+			Error
+		if err != nil {
+			return err
+		}
+		if err := entry.SaveEnrichment(tx); err != nil {
+			return err
+		}
+		return entry.SaveOverrides(tx)
+	})
+	if err != nil {
+		log.Error(f+"failed to update entry: ", err)
+		c.JSON(500, gin.H{"error": "Failed to update entry"})
+		return
+	}
 	c.JSON(200, gin.H{"message": "Success"})
 }
 
+// This API handler looks up the fields of the entry again by its ID,
+// except the fields set by hand. The changes are only previewed unless
+// the "apply" parameter is true. Return a JSON message with the diff or
+// an error with its cause.
+func Reenrich(c *gin.Context) {
+	f := logging.F()
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		log.Debug(f+"invalid entry ID: ", err)
+		respondInvalid(c, 400, models.Invalid(
+			"id", models.CodeInvalid, "Invalid ID parameter",
+		))
+		return
+	}
+	apply, err := strconv.ParseBool(c.DefaultQuery("apply", "false"))
+	if err != nil {
+		log.Debug(f+"invalid apply parameter: ", err)
+		respondInvalid(c, 400, models.Invalid(
+			"apply", models.CodeInvalid, "Invalid apply parameter",
+		))
+		return
+	}
+	var entry models.Entry
+	err = db.C.First(&entry, "id = ?", id).Error
+	if err != nil {
+		c.JSON(
+			404,
+			gin.H{"message": fmt.Sprintf(`Entry "%v" does not exist`, id)},
+		)
+		return
+	}
+	diff, err := pipeline.Reenrich(c.Request.Context(), &entry, apply)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"diff": diff})
+}
+
 // This API handler checks the input ID, deletes the record from the
 // database. Return a JSON success message or an error with its cause.
 func Delete(c *gin.Context) {
@@ -188,7 +376,9 @@ func Delete(c *gin.Context) {
 	var delEntry models.Entry
 	if err := c.ShouldBind(&delEntry); err != nil {
 		log.Debug(f+"parsing failed: ", err)
-		c.JSON(400, gin.H{"error": "Invalid API query"})
+		respondInvalid(c, 400, models.Invalid(
+			"body", models.CodeInvalid, "Invalid API query",
+		))
 		return
 	}
 	log.WithFields(logrus.Fields{
@@ -214,3 +404,216 @@ func Delete(c *gin.Context) {
 	}
 	c.JSON(200, gin.H{"message": "Success"})
 }
+
+// This API handler shows what would be saved for the "name", "surname"
+// and "patronymic" parameters: the enriched fields with their
+// confidence and the validation errors. Nothing is saved. Return a JSON
+// message with the preview or an error with its cause.
+func Preview(c *gin.Context) {
+	dataMsg := models.FullName{
+		Name:       c.Query("name"),
+		Surname:    c.Query("surname"),
+		Patronymic: c.Query("patronymic"),
+	}
+	preview, err := pipeline.PreviewEntry(c.Request.Context(), dataMsg)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{"preview": preview})
+}
+
+// This API handler shows what would be saved for every message of the
+// JSON list, the messages are processed concurrently and their names
+// are looked up in batches. Nothing is saved.
+// Return a JSON message with the previews or the errors of the
+// messages in the same order, otherwise an error with its cause.
+func PreviewBatch(c *gin.Context) {
+	f := logging.F()
+	var dataMsgs []models.FullName
+	if err := c.ShouldBindJSON(&dataMsgs); err != nil {
+		log.Debug(f+"parsing failed: ", err)
+		respondInvalid(c, 400, models.Invalid(
+			"body", models.CodeInvalid, "Invalid API query",
+		))
+		return
+	}
+	switch {
+	case len(dataMsgs) == 0:
+		respondInvalid(c, 400, models.Invalid(
+			"body", models.CodeRequired, "No messages to preview",
+		))
+		return
+	case len(dataMsgs) > PreviewBatchSize:
+		var errs models.ValidationErrors
+		errs.Add(
+			"body", models.CodeTooMany,
+			fmt.Sprintf(
+				"Too many messages, the limit is %d", PreviewBatchSize,
+			),
+			map[string]interface{}{"max": PreviewBatchSize},
+		)
+		respondInvalid(c, 400, errs)
+		return
+	}
+	ctx := requests.Bulk(c.Request.Context())
+	results := make([]gin.H, len(dataMsgs))
+	var tasks sync.WaitGroup
+	for i := range dataMsgs {
+		tasks.Add(1)
+		go func(i int) {
+			defer tasks.Done()
+			preview, err := pipeline.PreviewEntry(ctx, dataMsgs[i])
+			var perr *pipeline.Error
+			switch {
+			case errors.As(err, &perr):
+				results[i] = gin.H{"error": perr.Message, "code": perr.Code}
+				if len(perr.Errors) != 0 {
+					results[i]["errors"] = perr.Errors
+				}
+			case err != nil:
+				results[i] = gin.H{"error": err.Error(), "code": 500}
+			default:
+				results[i] = gin.H{"preview": preview}
+			}
+		}(i)
+	}
+	tasks.Wait()
+	if ctx.Err() != nil {
+		c.AbortWithStatus(499)
+		return
+	}
+	c.JSON(200, gin.H{"previews": results})
+}
+
+// This API handler returns the provenance of the enriched fields of the
+// entry by its ID, the latest first. The "field" parameter limits the
+// records to the given field. Return a JSON message with the records or
+// an error with its cause.
+func Provenance(c *gin.Context) {
+	f := logging.F()
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		log.Debug(f+"invalid entry ID: ", err)
+		respondInvalid(c, 400, models.Invalid(
+			"id", models.CodeInvalid, "Invalid ID parameter",
+		))
+		return
+	}
+	var entry models.Entry
+	err = db.C.First(&entry, "id = ?", id).Error
+	if err != nil {
+		c.JSON(
+			404,
+			gin.H{"message": fmt.Sprintf(`Entry "%v" does not exist`, id)},
+		)
+		return
+	}
+	query := db.C.Where("entry_id = ?", entry.ID)
+	if field := c.Query("field"); field != "" {
+		query = query.Where("field = ?", field)
+	}
+	var records []models.Provenance
+	err = query.Order("created_at DESC, id DESC").Find(&records).Error
+	if err != nil {
+		log.Error(f+"request to the database failed: ", err)
+		c.JSON(500, gin.H{"error": "Request failed"})
+		return
+	}
+	list := []gin.H{}
+	for _, record := range records {
+		var response interface{}
+		if record.Response != "" {
+			response = json.RawMessage(record.Response)
+		}
+		list = append(list, gin.H{
+			"Field":      record.Field,
+			"Provider":   record.Provider,
+			"URL":        record.URL,
+			"Response":   response,
+			"Confidence": record.Confidence,
+			"Time":       record.CreatedAt,
+		})
+	}
+	c.JSON(200, gin.H{"entry": entry.ID, "provenance": list})
+}
+
+// This API handler clears the override of the entry field set by hand
+// and looks the field up again. Return a JSON success message with the
+// new value or an error with its cause.
+func ClearOverride(c *gin.Context) {
+	f := logging.F()
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		log.Debug(f+"invalid entry ID: ", err)
+		respondInvalid(c, 400, models.Invalid(
+			"id", models.CodeInvalid, "Invalid ID parameter",
+		))
+		return
+	}
+	field := enrich.Field(c.Param("field"))
+	if !field.Valid() {
+		respondInvalid(c, 400, models.Invalid(
+			"field", models.CodeInvalid, "Invalid field parameter",
+		))
+		return
+	}
+	var entry models.Entry
+	err = db.C.First(&entry, "id = ?", id).Error
+	if err != nil {
+		c.JSON(
+			404,
+			gin.H{"message": fmt.Sprintf(`Entry "%v" does not exist`, id)},
+		)
+		return
+	}
+	if !entry.Overridden(field) {
+		c.JSON(
+			404,
+			gin.H{"message": fmt.Sprintf(
+				`Field "%v" of entry "%v" is not overridden`, field, id,
+			)},
+		)
+		return
+	}
+	err = pipeline.ClearOverride(c.Request.Context(), &entry, field)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "Success",
+		"field":   field,
+		"value":   entry.Value(field),
+		"status":  entry.Status(field),
+	})
+}
+
+// This API handler returns the last known quotas of the external APIs
+// parsed from their X-Rate-Limit headers. The quota is null until the
+// first response is received.
+func Quota(c *gin.Context) {
+	c.JSON(200, gin.H{"quota": requests.Quotas()})
+}
+
+// This API handler returns the circuit breaker states of the external
+// APIs.
+func Breakers(c *gin.Context) {
+	c.JSON(200, gin.H{"breakers": requests.Breakers()})
+}
+
+// This API handler returns the hit/miss counters of the enrichment
+// cache in total and per tier.
+func Cache(c *gin.Context) {
+	c.JSON(200, gin.H{"cache": cache.Default.Stats()})
+}
+
+// Returns the number of seconds until the given time for the
+// Retry-After header.
+func retryAfter(until time.Time) string {
+	seconds := int(math.Ceil(time.Until(until).Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
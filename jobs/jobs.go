@@ -0,0 +1,184 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"people2/config"
+	db "people2/database"
+	"people2/logging"
+	"people2/models"
+	"people2/pipeline"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	log = logging.Config
+	// The number of the workers processing the jobs.
+	Workers = config.Int("JOBS_WORKERS", 4)
+	// The time limit of a single job.
+	Timeout = config.Duration("JOBS_TIMEOUT", 30*time.Second)
+	// The pause between the checks of the queued jobs in the database.
+	PollInterval = config.Duration("JOBS_POLL_INTERVAL", 10*time.Second)
+	queue        = make(chan string, config.Int("JOBS_QUEUE_SIZE", 1024))
+)
+
+// The function saves the queued job for the message and hands it to the
+// workers. The job waits in the database if the queue is full.
+func Submit(dataMsg models.FullName) (*models.Job, error) {
+	job := &models.Job{
+		ID:         uuid.NewString(),
+		Status:     models.JobQueued,
+		Name:       dataMsg.Name,
+		Surname:    dataMsg.Surname,
+		Patronymic: dataMsg.Patronymic,
+	}
+	err := db.C.Create(job).Error
+	if err != nil {
+		return nil, err
+	}
+	enqueue(job.ID)
+	return job, nil
+}
+
+// The function returns the job by its ID.
+func Get(id string) (*models.Job, error) {
+	var job models.Job
+	err := db.C.First(&job, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// The function starts the workers and requeues the unfinished jobs of
+// the previous run. The jobs that did not fit in the queue are picked
+// up on the next poll. The workers stop when the context is done.
+func Start(ctx context.Context) {
+	f := logging.F()
+	err := db.C.Model(&models.Job{}).
+		Where("status = ?", models.JobRunning).
+		Update("status", models.JobQueued).
+		Error
+	if err != nil {
+		log.Error(f+"failed to requeue jobs: ", err)
+	}
+	for i := 0; i < Workers; i++ {
+		go worker(ctx)
+	}
+	go func() {
+		ticker := time.NewTicker(PollInterval)
+		defer ticker.Stop()
+		for {
+			poll()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// The function enqueues the queued jobs from the database.
+func poll() {
+	f := logging.F()
+	free := cap(queue) - len(queue)
+	if free == 0 {
+		return
+	}
+	var ids []string
+	err := db.C.Model(&models.Job{}).
+		Where("status = ?", models.JobQueued).
+		Order("created_at").
+		Limit(free).
+		Pluck("id", &ids).
+		Error
+	if err != nil {
+		log.Error(f+"failed to poll jobs: ", err)
+		return
+	}
+	for _, id := range ids {
+		enqueue(id)
+	}
+}
+
+// The function claims the queued job and hands it to the workers. The
+// claim is a conditional update, so the job is handed out once even if
+// it is polled again before it runs. The job is returned to the
+// database if the queue is full.
+func enqueue(id string) {
+	f := logging.F()
+	claim := db.C.Model(&models.Job{}).
+		Where("id = ? AND status = ?", id, models.JobQueued).
+		Update("status", models.JobRunning)
+	if claim.Error != nil {
+		log.Error(f+"failed to claim job: ", claim.Error)
+		return
+	}
+	if claim.RowsAffected == 0 {
+		// The job is handed out already
+		return
+	}
+	select {
+	case queue <- id:
+	default:
+		log.Warn(f+"jobs queue is full, job waits for poll: ", id)
+		err := db.C.Model(&models.Job{}).
+			Where("id = ? AND status = ?", id, models.JobRunning).
+			Update("status", models.JobQueued).
+			Error
+		if err != nil {
+			log.Error(f+"failed to return job: ", err)
+		}
+	}
+}
+
+func worker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-queue:
+			run(ctx, id)
+		}
+	}
+}
+
+// The function runs the pipeline of the claimed job and saves its
+// result.
+func run(ctx context.Context, id string) {
+	f := logging.F()
+	job, err := Get(id)
+	if err != nil {
+		log.Error(f+"failed to load job: ", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+	entry, err := pipeline.Process(ctx, models.FullName{
+		Name:       job.Name,
+		Surname:    job.Surname,
+		Patronymic: job.Patronymic,
+	})
+	updates := map[string]interface{}{"status": models.JobDone}
+	var perr *pipeline.Error
+	switch {
+	case err == nil:
+		updates["entry_id"] = entry.ID
+	case errors.As(err, &perr):
+		updates["status"] = models.JobFailed
+		updates["code"] = perr.Code
+		updates["error"] = perr.Message
+		updates["errors"] = perr.Errors
+	default:
+		updates["status"] = models.JobFailed
+		updates["code"] = 500
+		updates["error"] = err.Error()
+	}
+	err = db.C.Model(job).Updates(updates).Error
+	if err != nil {
+		log.Error(f+"failed to save job result: ", err)
+	}
+}
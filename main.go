@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
+	"people2/backfill"
+	"people2/cache"
 	db "people2/database"
+	"people2/enrich"
 	"people2/handlers"
+	"people2/jobs"
 	"people2/logging"
 	"people2/models"
+	"people2/queue"
 
 	"github.com/gin-gonic/contrib/secure"
 	"github.com/gin-gonic/gin"
@@ -30,9 +36,33 @@ var (
 )
 
 func main() {
+	// Select the enrichment providers
+	if err := enrich.Configure(); err != nil {
+		log.Fatal("failed to select enricher: ", err)
+	}
+
 	// Connect to database
 	db.Connect()
-	db.C.AutoMigrate(&models.Entry{})
+	db.C.AutoMigrate(append(models.Tables, &cache.Record{})...)
+	if err := cache.Purge(); err != nil {
+		log.Error("failed to purge enrichment cache: ", err)
+	}
+
+	// Retry the pending fields
+	if models.PartialMode {
+		backfill.Start(context.Background())
+	}
+
+	// Process the asynchronous creation
+	jobs.Start(context.Background())
+
+	// Consume the FIO messages
+	broker, err := queue.New(queue.Kind)
+	if err != nil {
+		log.Fatal("failed to open queue broker: ", err)
+	}
+	queue.Default = broker
+	queue.Start(context.Background(), broker)
 
 	// Run router
 	r := router()
@@ -53,5 +83,15 @@ func router() *gin.Engine {
 	api.GET("/read", handlers.Read)
 	api.PATCH("/update", handlers.Update)
 	api.DELETE("/delete", handlers.Delete)
+	api.GET("/jobs/:id", handlers.Job)
+	api.GET("/enrich", handlers.Preview)
+	api.POST("/enrich", handlers.PreviewBatch)
+	api.GET("/people/:id/provenance", handlers.Provenance)
+	api.POST("/people/:id/enrich", handlers.Reenrich)
+	api.DELETE("/people/:id/overrides/:field", handlers.ClearOverride)
+	admin := api.Group("/admin")
+	admin.GET("/quota", handlers.Quota)
+	admin.GET("/cache", handlers.Cache)
+	admin.GET("/breakers", handlers.Breakers)
 	return r
 }
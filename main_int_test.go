@@ -2,14 +2,21 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	db "people2/database"
+	"people2/enrich"
+	"people2/jobs"
 	"people2/models"
+	"people2/pipeline"
+	"people2/queue"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/joho/godotenv/autoload"
@@ -18,6 +25,11 @@ import (
 
 // Requirements: .env PostgreSQL credentials
 
+// The external APIs are replaced with the deterministic provider.
+func init() {
+	enrich.Use("fake")
+}
+
 // Testing data processing in the handlers.Create() function.
 func TestCreateAPI(t *testing.T) {
 	type args struct {
@@ -60,6 +72,16 @@ func TestCreateAPI(t *testing.T) {
 				},
 			},
 		},
+		{
+			test: "Name mixing Latin and Cyrillic letters was rejected",
+			args: args{
+				valid: false,
+				data: models.FullName{
+					Name:    "Iv\u0430n",
+					Surname: "Ivanov",
+				},
+			},
+		},
 		{
 			test: "Empty name was rejected",
 			args: args{
@@ -208,8 +230,8 @@ func TestCreateAPI(t *testing.T) {
 			// Setup test database
 			gin.SetMode(gin.TestMode)
 			db.Connect()
-			db.C.AutoMigrate(&models.Entry{})
-			defer db.C.Migrator().DropTable(&models.Entry{})
+			db.C.AutoMigrate(models.Tables...)
+			defer db.C.Migrator().DropTable(models.Tables...)
 
 			// Create testing data
 			send := tt.args.data
@@ -239,11 +261,378 @@ func TestCreateAPI(t *testing.T) {
 			} else {
 				assert.NotEqual(t, 200, response.Code)
 				assert.Error(t, err)
+				var result struct{ Errors []models.FieldError }
+				err = json.Unmarshal(response.Body.Bytes(), &result)
+				assert.NoError(t, err)
+				assert.NotEmpty(t, result.Errors)
 			}
 		})
 	}
 }
 
+// Testing the asynchronous creation in the handlers.Create() and
+// handlers.Job() functions.
+func TestCreateAsyncAPI(t *testing.T) {
+	// Setup test database
+	gin.SetMode(gin.TestMode)
+	db.Connect()
+	db.C.AutoMigrate(models.Tables...)
+	defer db.C.Migrator().DropTable(models.Tables...)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	jobs.Start(ctx)
+
+	// Create testing data
+	send := models.FullName{Name: "Ivan", Surname: "Ivanov"}
+	jsonData, err := json.Marshal(send)
+	assert.NoError(t, err)
+
+	// Setup router
+	r := router()
+	request, err := http.NewRequest(
+		"POST",
+		"http://127.0.0.1:8080/api/create?async=true",
+		bytes.NewBuffer(jsonData),
+	)
+	assert.NoError(t, err)
+	request.Header.Set("Content-Type", "application/json")
+	response := httptest.NewRecorder()
+	r.ServeHTTP(response, request)
+	assert.Equal(t, 202, response.Code)
+	var accepted struct{ Job string }
+	err = json.Unmarshal(response.Body.Bytes(), &accepted)
+	assert.NoError(t, err)
+
+	// Poll the job status
+	var result struct{ Job models.Job }
+	assert.Eventually(t, func() bool {
+		request, _ := http.NewRequest(
+			"GET",
+			"http://127.0.0.1:8080/api/jobs/"+accepted.Job,
+			nil,
+		)
+		response := httptest.NewRecorder()
+		r.ServeHTTP(response, request)
+		json.Unmarshal(response.Body.Bytes(), &result)
+		return result.Job.Status == models.JobDone
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// Estimation of values
+	var entry models.Entry
+	err = db.C.First(&entry).Error
+	assert.NoError(t, err)
+	assert.Equal(t, entry.ID, *result.Job.EntryID)
+}
+
+// Testing the enrichment provenance in the handlers.Provenance()
+// function.
+func TestProvenanceAPI(t *testing.T) {
+	// Setup test database
+	gin.SetMode(gin.TestMode)
+	db.Connect()
+	db.C.AutoMigrate(models.Tables...)
+	defer db.C.Migrator().DropTable(models.Tables...)
+
+	// Create testing data
+	send := models.FullName{Name: "Ivan", Surname: "Ivanov"}
+	jsonData, err := json.Marshal(send)
+	assert.NoError(t, err)
+	r := router()
+	request, err := http.NewRequest(
+		"POST",
+		"http://127.0.0.1:8080/api/create",
+		bytes.NewBuffer(jsonData),
+	)
+	assert.NoError(t, err)
+	request.Header.Set("Content-Type", "application/json")
+	response := httptest.NewRecorder()
+	r.ServeHTTP(response, request)
+	assert.Equal(t, 200, response.Code)
+	var entry models.Entry
+	err = db.C.First(&entry).Error
+	assert.NoError(t, err)
+
+	// Estimation of values
+	request, err = http.NewRequest(
+		"GET",
+		fmt.Sprintf("http://127.0.0.1:8080/api/people/%d/provenance", entry.ID),
+		nil,
+	)
+	assert.NoError(t, err)
+	response = httptest.NewRecorder()
+	r.ServeHTTP(response, request)
+	assert.Equal(t, 200, response.Code)
+	var result struct {
+		Provenance []struct {
+			Field      string
+			Provider   string
+			Confidence float64
+		}
+	}
+	err = json.Unmarshal(response.Body.Bytes(), &result)
+	assert.NoError(t, err)
+	assert.Len(t, result.Provenance, 3)
+	for _, record := range result.Provenance {
+		assert.Equal(t, "fake", record.Provider)
+	}
+	request, err = http.NewRequest(
+		"GET",
+		"http://127.0.0.1:8080/api/people/999999/provenance",
+		nil,
+	)
+	assert.NoError(t, err)
+	response = httptest.NewRecorder()
+	r.ServeHTTP(response, request)
+	assert.Equal(t, 404, response.Code)
+}
+
+// Testing the re-enrichment in the handlers.Reenrich() function.
+func TestReenrichAPI(t *testing.T) {
+	// Setup test database
+	gin.SetMode(gin.TestMode)
+	db.Connect()
+	db.C.AutoMigrate(models.Tables...)
+	defer db.C.Migrator().DropTable(models.Tables...)
+	data := models.Entry{
+		Name:        "Ivan",
+		Surname:     "Ivanov",
+		Age:         1,
+		Gender:      "female",
+		Nationality: "ZZ",
+	}
+	err := db.C.Create(&data).Error
+	assert.NoError(t, err)
+
+	// The age is set by hand
+	r := router()
+	send := data
+	send.Age = 33
+	jsonData, err := json.Marshal(send)
+	assert.NoError(t, err)
+	request, err := http.NewRequest(
+		"PATCH",
+		"http://127.0.0.1:8080/api/update",
+		bytes.NewBuffer(jsonData),
+	)
+	assert.NoError(t, err)
+	request.Header.Set("Content-Type", "application/json")
+	response := httptest.NewRecorder()
+	r.ServeHTTP(response, request)
+	assert.Equal(t, 200, response.Code)
+
+	// Estimation of values
+	reenrich := func(apply bool) pipeline.Diff {
+		request, err := http.NewRequest(
+			"POST",
+			fmt.Sprintf(
+				"http://127.0.0.1:8080/api/people/%d/enrich?apply=%t",
+				data.ID, apply,
+			),
+			nil,
+		)
+		assert.NoError(t, err)
+		response := httptest.NewRecorder()
+		r.ServeHTTP(response, request)
+		assert.Equal(t, 200, response.Code)
+		var result struct{ Diff pipeline.Diff }
+		err = json.Unmarshal(response.Body.Bytes(), &result)
+		assert.NoError(t, err)
+		return result.Diff
+	}
+	diff := reenrich(false)
+	assert.False(t, diff.Applied)
+	assert.Equal(t, []enrich.Field{enrich.FieldAge}, diff.Manual)
+	assert.Len(t, diff.Changes, 2)
+	var entry models.Entry
+	err = db.C.First(&entry, data.ID).Error
+	assert.NoError(t, err)
+	assert.Equal(t, "ZZ", entry.Nationality)
+
+	diff = reenrich(true)
+	assert.True(t, diff.Applied)
+	err = db.C.First(&entry, data.ID).Error
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(33), entry.Age)
+	assert.Equal(t, "male", entry.Gender)
+	assert.NotEqual(t, "ZZ", entry.Nationality)
+}
+
+// Testing the overrides in the handlers.Update() and
+// handlers.ClearOverride() functions.
+func TestClearOverrideAPI(t *testing.T) {
+	// Setup test database
+	gin.SetMode(gin.TestMode)
+	db.Connect()
+	db.C.AutoMigrate(models.Tables...)
+	defer db.C.Migrator().DropTable(models.Tables...)
+	data := models.Entry{
+		Name:        "Ivan",
+		Surname:     "Ivanov",
+		Age:         42,
+		Gender:      "male",
+		Nationality: "RU",
+	}
+	err := db.C.Create(&data).Error
+	assert.NoError(t, err)
+
+	// The nationality is set by hand
+	r := router()
+	send := data
+	send.Nationality = "KZ"
+	jsonData, err := json.Marshal(send)
+	assert.NoError(t, err)
+	request, err := http.NewRequest(
+		"PATCH",
+		"http://127.0.0.1:8080/api/update",
+		bytes.NewBuffer(jsonData),
+	)
+	assert.NoError(t, err)
+	request.Header.Set("Content-Type", "application/json")
+	response := httptest.NewRecorder()
+	r.ServeHTTP(response, request)
+	assert.Equal(t, 200, response.Code)
+	var entry models.Entry
+	err = db.C.First(&entry, data.ID).Error
+	assert.NoError(t, err)
+	assert.Equal(t, []enrich.Field{enrich.FieldNationality}, entry.Overrides())
+	assert.Equal(t, models.SourceManual, entry.NationalitySource)
+
+	// Estimation of values
+	clearOverride := func(field string) int {
+		request, err := http.NewRequest(
+			"DELETE",
+			fmt.Sprintf(
+				"http://127.0.0.1:8080/api/people/%d/overrides/%s",
+				data.ID, field,
+			),
+			nil,
+		)
+		assert.NoError(t, err)
+		response := httptest.NewRecorder()
+		r.ServeHTTP(response, request)
+		return response.Code
+	}
+	assert.Equal(t, 400, clearOverride("height"))
+	assert.Equal(t, 404, clearOverride("age"))
+	assert.Equal(t, 200, clearOverride("nationality"))
+	err = db.C.First(&entry, data.ID).Error
+	assert.NoError(t, err)
+	assert.Empty(t, entry.Overrides())
+	assert.Equal(t, "fake", entry.NationalitySource)
+	assert.Equal(t, 404, clearOverride("nationality"))
+}
+
+// Testing the enrichment preview in the handlers.Preview() and
+// handlers.PreviewBatch() functions.
+func TestPreviewAPI(t *testing.T) {
+	// Setup test database
+	gin.SetMode(gin.TestMode)
+	db.Connect()
+	db.C.AutoMigrate(models.Tables...)
+	defer db.C.Migrator().DropTable(models.Tables...)
+	r := router()
+
+	// Single message
+	request, err := http.NewRequest(
+		"GET",
+		"http://127.0.0.1:8080/api/enrich?name=Ivan&surname=Ivanov",
+		nil,
+	)
+	assert.NoError(t, err)
+	response := httptest.NewRecorder()
+	r.ServeHTTP(response, request)
+	assert.Equal(t, 200, response.Code)
+	var single struct{ Preview pipeline.Preview }
+	err = json.Unmarshal(response.Body.Bytes(), &single)
+	assert.NoError(t, err)
+	assert.True(t, single.Preview.Valid)
+	assert.Equal(t, "male", single.Preview.Gender)
+	assert.NotZero(t, single.Preview.GenderProbability)
+
+	// Batch of messages
+	jsonData, err := json.Marshal([]models.FullName{
+		{Name: "Maria", Surname: "Ivanova"},
+		{Name: "1Ivan", Surname: "Ivanov"},
+	})
+	assert.NoError(t, err)
+	request, err = http.NewRequest(
+		"POST",
+		"http://127.0.0.1:8080/api/enrich",
+		bytes.NewBuffer(jsonData),
+	)
+	assert.NoError(t, err)
+	request.Header.Set("Content-Type", "application/json")
+	response = httptest.NewRecorder()
+	r.ServeHTTP(response, request)
+	assert.Equal(t, 200, response.Code)
+	var batch struct{ Previews []struct{ Preview pipeline.Preview } }
+	err = json.Unmarshal(response.Body.Bytes(), &batch)
+	assert.NoError(t, err)
+	assert.Len(t, batch.Previews, 2)
+	assert.True(t, batch.Previews[0].Preview.Valid)
+	assert.Equal(t, "female", batch.Previews[0].Preview.Gender)
+	assert.False(t, batch.Previews[1].Preview.Valid)
+	assert.NotEmpty(t, batch.Previews[1].Preview.Errors)
+
+	// Nothing is saved
+	var count int64
+	err = db.C.Model(&models.Entry{}).Count(&count).Error
+	assert.NoError(t, err)
+	assert.Zero(t, count)
+}
+
+// Testing the ingestion of the FIO messages in the queue package.
+func TestQueueConsumer(t *testing.T) {
+	// Setup test database
+	gin.SetMode(gin.TestMode)
+	db.Connect()
+	db.C.AutoMigrate(models.Tables...)
+	defer db.C.Migrator().DropTable(models.Tables...)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := queue.NewMemory()
+	consumer := &queue.Consumer{
+		Broker:      broker,
+		Topic:       "fio",
+		FailedTopic: "fio.failed",
+	}
+	go consumer.Run(ctx)
+
+	// Create testing data
+	messages := []string{
+		`{"name":"Ivan","surname":"Ivanov"}`,
+		`{"name":"1Ivan","surname":"Ivanov"}`,
+		`"not a FIO"`,
+	}
+	for i, payload := range messages {
+		err := broker.Publish(ctx, "fio", queue.Message{
+			ID:      fmt.Sprint(i),
+			Payload: json.RawMessage(payload),
+		})
+		assert.NoError(t, err)
+	}
+
+	// Estimation of values
+	var mu sync.Mutex
+	var failed []queue.Message
+	go broker.Consume(ctx, "fio.failed", func(msg queue.Message) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed = append(failed, msg)
+	})
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(failed) == 2
+	}, 5*time.Second, 50*time.Millisecond)
+	var entries []models.Entry
+	err := db.C.Find(&entries).Error
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "1", failed[0].ID)
+	assert.NotEmpty(t, failed[0].Error)
+}
+
 // Testing data processing in the handlers.Read() function.
 func TestReadAPI(t *testing.T) {
 	type args struct {
@@ -439,8 +828,8 @@ func TestReadAPI(t *testing.T) {
 			// Setup test database
 			gin.SetMode(gin.TestMode)
 			db.Connect()
-			db.C.AutoMigrate(&models.Entry{})
-			defer db.C.Migrator().DropTable(&models.Entry{})
+			db.C.AutoMigrate(models.Tables...)
+			defer db.C.Migrator().DropTable(models.Tables...)
 
 			// Create testing data
 			db.C.Create(&tt.args.entries)
@@ -528,13 +917,69 @@ func TestReadAPI(t *testing.T) {
 	}
 }
 
+// Testing the nationality candidates filter in the handlers.Read()
+// function.
+func TestReadCandidatesAPI(t *testing.T) {
+	// Setup test database
+	gin.SetMode(gin.TestMode)
+	db.Connect()
+	db.C.AutoMigrate(models.Tables...)
+	defer db.C.Migrator().DropTable(models.Tables...)
+	data := []models.Entry{
+		{
+			Name:        "Ivan",
+			Surname:     "Ivanov",
+			Age:         42,
+			Gender:      "male",
+			Nationality: "RU",
+			Candidates: []models.NationalityCandidate{
+				{CountryID: "RU", Probability: 0.6},
+				{CountryID: "DE", Probability: 0.3},
+			},
+		},
+		{
+			Name:        "Anna",
+			Surname:     "Ivanova",
+			Age:         42,
+			Gender:      "female",
+			Nationality: "RU",
+			Candidates: []models.NationalityCandidate{
+				{CountryID: "RU", Probability: 0.9},
+				{CountryID: "DE", Probability: 0.05},
+			},
+		},
+	}
+	err := db.C.Create(&data).Error
+	assert.NoError(t, err)
+
+	// Setup router
+	r := router()
+	request, err := http.NewRequest(
+		"GET",
+		"http://127.0.0.1:8080/api/read?country=DE&probability=0.2&candidates=true",
+		nil,
+	)
+	assert.NoError(t, err)
+	response := httptest.NewRecorder()
+	r.ServeHTTP(response, request)
+
+	// Estimation of values
+	var result struct{ Entries []models.Entry }
+	err = json.Unmarshal(response.Body.Bytes(), &result)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, response.Code)
+	assert.Len(t, result.Entries, 1)
+	assert.Equal(t, "Ivan", result.Entries[0].Name)
+	assert.Len(t, result.Entries[0].Candidates, 2)
+}
+
 // Testing data processing in the handlers.Update() function.
 func TestUpdateAPI(t *testing.T) {
 	// Setup test database
 	gin.SetMode(gin.TestMode)
 	db.Connect()
-	db.C.AutoMigrate(&models.Entry{})
-	defer db.C.Migrator().DropTable(&models.Entry{})
+	db.C.AutoMigrate(models.Tables...)
+	defer db.C.Migrator().DropTable(models.Tables...)
 	data := models.Entry{
 		Name:        "Ivan",
 		Surname:     "Ivanov",
@@ -579,6 +1024,29 @@ func TestUpdateAPI(t *testing.T) {
 	assert.Equal(t, 200, response.Code)
 	assert.NoError(t, err)
 	assert.Equal(t, send.Surname, entry.Surname)
+
+	// Invalid data is rejected with the field errors
+	send.Age = 130
+	jsonData, err = json.Marshal(send)
+	assert.NoError(t, err)
+	request, err = http.NewRequest(
+		"PATCH",
+		"http://127.0.0.1:8080/api/update",
+		bytes.NewBuffer(jsonData),
+	)
+	assert.NoError(t, err)
+	request.Header.Set("Content-Type", "application/json")
+	response = httptest.NewRecorder()
+	r.ServeHTTP(response, request)
+	assert.Equal(t, 422, response.Code)
+	var invalid struct {
+		Error  string
+		Errors models.ValidationErrors
+	}
+	err = json.Unmarshal(response.Body.Bytes(), &invalid)
+	assert.NoError(t, err)
+	assert.Equal(t, "age", invalid.Errors[0].Field)
+	assert.Equal(t, models.CodeOutOfRange, invalid.Errors[0].Code)
 }
 
 // Testing data processing in the handlers.Delete() function.
@@ -586,8 +1054,8 @@ func TestDeleteAPI(t *testing.T) {
 	// Setup test database
 	gin.SetMode(gin.TestMode)
 	db.Connect()
-	db.C.AutoMigrate(&models.Entry{})
-	defer db.C.Migrator().DropTable(&models.Entry{})
+	db.C.AutoMigrate(models.Tables...)
+	defer db.C.Migrator().DropTable(models.Tables...)
 	data := models.Entry{
 		Name:        "Ivan",
 		Surname:     "Ivanov",
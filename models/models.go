@@ -1,17 +1,42 @@
 package models
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"people2/config"
+	"people2/enrich"
 	"people2/logging"
-	"people2/requests"
 	"regexp"
-	"strings"
-	"sync"
+	"time"
 
 	"gorm.io/gorm"
 )
 
-var log = logging.Config
+var (
+	log = logging.Config
+	// The minimal probabilities of the enriched data.
+	MinGenderProbability      = config.Float("MIN_GENDER_PROBABILITY", 0)
+	MinNationalityProbability = config.Float(
+		"MIN_NATIONALITY_PROBABILITY", 0,
+	)
+	// The handling of the low confidence data: "reject" or "flag".
+	ConfidenceMode = config.String("CONFIDENCE_MODE", "flag")
+	// The Entry is saved with the fields that were enriched, the others
+	// are left pending for the backfill.
+	PartialMode = config.Bool("ENRICH_PARTIAL", false)
+)
+
+// The enrichment statuses of the Entry fields.
+const (
+	StatusOK      = "ok"
+	StatusPending = "pending"
+	StatusFailed  = "failed"
+)
+
+// The source of the fields set by hand through the update. The
+// enrichment never changes such fields.
+const SourceManual = "manual"
 
 // The model for parsing data from the requests.
 type FullName struct {
@@ -22,36 +47,13 @@ type FullName struct {
 }
 
 // The method of the data validity checking in the FullName model.
-func (e *FullName) IsValid() string {
-	namePattern := `^[a-zA-Zа-яА-Я]+$`
-	var errContent []string
-	// Name
-	switch {
-	case e.Name == "":
-		errContent = append(errContent, "name cannot be empty")
-	case len(e.Name) < 2:
-		errContent = append(errContent, "name is too short")
-	case len(e.Name) > 50:
-		errContent = append(errContent, "name is too long")
-	case !regexp.MustCompile(namePattern).MatchString(e.Name):
-		errContent = append(errContent, "name contains invalid characters")
-	}
-	// Surname
-	switch {
-	case e.Surname == "":
-		errContent = append(errContent, "surname cannot be empty")
-	case len(e.Surname) < 2:
-		errContent = append(errContent, "surname is too short")
-	case len(e.Surname) > 50:
-		errContent = append(errContent, "surname is too long")
-	case !regexp.MustCompile(namePattern).MatchString(e.Surname):
-		errContent = append(errContent, "surname contains invalid characters")
-	}
-	if len(errContent) == 0 {
-		return ""
-	}
-	err := strings.Join(errContent, ", ")
-	return err
+// Returns ValidationErrors with the invalid fields, otherwise nil.
+func (e *FullName) IsValid() error {
+	var errs ValidationErrors
+	checkName(&errs, "name", e.Name, true)
+	checkName(&errs, "surname", e.Surname, true)
+	checkName(&errs, "patronymic", e.Patronymic, false)
+	return errs.Err()
 }
 
 // The model for saving data in the database.
@@ -64,82 +66,587 @@ type Entry struct {
 	Age         uint8  `gorm:"not null"`
 	Gender      string `gorm:"not null"`
 	Nationality string `gorm:"not null"`
+
+	GenderProbability      float64 `gorm:"default:0"`
+	GenderCount            int     `gorm:"default:0"`
+	NationalityProbability float64 `gorm:"default:0"`
+	LowConfidence          bool    `gorm:"default:false"`
+	GenderConflict         bool    `gorm:"default:false"`
+
+	// The probabilities of the nationality by the name and the surname,
+	// combined into NationalityProbability
+	NationalityNameProbability    float64 `gorm:"default:0"`
+	NationalitySurnameProbability float64 `gorm:"default:0"`
+
+	AgeStatus         string `gorm:"default:'ok';index"`
+	GenderStatus      string `gorm:"default:'ok';index"`
+	NationalityStatus string `gorm:"default:'ok';index"`
+	EnrichAttempts    int    `gorm:"default:0"`
+
+	AgeSource         string `gorm:"default:''"`
+	GenderSource      string `gorm:"default:''"`
+	NationalitySource string `gorm:"default:''"`
+
+	AgeOverride         *time.Time
+	GenderOverride      *time.Time
+	NationalityOverride *time.Time
+
+	AgeCountry    string `gorm:"default:''"`
+	GenderCountry string `gorm:"default:''"`
+	EnrichMode    string `gorm:"default:'global'"`
+
+	// The original spellings of the name parts normalized from the mixed
+	// scripts
+	MixedScript Spellings `gorm:"type:text" json:",omitempty"`
+
+	Candidates []NationalityCandidate `gorm:"foreignKey:EntryID;constraint:OnDelete:CASCADE" json:",omitempty"`
+	Provenance []Provenance           `gorm:"foreignKey:EntryID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// The model for saving the nationality candidates of the Entry.
+type NationalityCandidate struct {
+	ID                 uint    `gorm:"primarykey" json:"-"`
+	EntryID            uint    `gorm:"index;not null" json:"-"`
+	CountryID          string  `gorm:"not null"`
+	Probability        float64 `gorm:"not null"`
+	NameProbability    float64 `gorm:"default:0"`
+	SurnameProbability float64 `gorm:"default:0"`
+}
+
+// The model for saving the origin of an enriched field of the Entry.
+// The records are only added, so the history of every field is kept.
+// URL has no API key, Response is the raw JSON answer of the provider.
+type Provenance struct {
+	ID         uint      `gorm:"primarykey"`
+	EntryID    uint      `gorm:"index;not null" json:"-"`
+	Field      string    `gorm:"not null"`
+	Provider   string    `gorm:"not null"`
+	URL        string    `gorm:"default:''"`
+	Response   string    `gorm:"type:text;default:''"`
+	Confidence float64   `gorm:"default:0"`
+	CreatedAt  time.Time `gorm:"not null"`
+}
+
+// The statuses of the Job.
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// The model for saving the asynchronous creation of the Entry. Code and
+// Error hold the status code and the cause of the failure, Errors holds
+// the invalid fields.
+type Job struct {
+	ID         string `gorm:"primarykey"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Status     string `gorm:"not null;index"`
+	Name       string `gorm:"not null"`
+	Surname    string `gorm:"not null"`
+	Patronymic string `gorm:"default:''"`
+	EntryID    *uint
+	Code       int    `gorm:"default:0"`
+	Error      string `gorm:"default:''"`
+
+	Errors ValidationErrors `gorm:"type:text" json:",omitempty"`
+}
+
+// The list of the models saved in the database, in the order of their
+// dependencies.
+var Tables = []interface{}{
+	&Entry{}, &NationalityCandidate{}, &Provenance{}, &Job{},
 }
 
 // The method of the data validity checking in the Entry model.
+// Returns ValidationErrors with the invalid fields, otherwise nil.
 func (e *Entry) IsValid() error {
-	namePattern := `^[a-zA-Zа-яА-Я]+$`
 	countryPattern := `^[A-Z]{2}$`
-	var errContent []string
-	// Name
-	switch {
-	case e.Name == "":
-		errContent = append(errContent, "name cannot be empty")
-	case len(e.Name) < 2:
-		errContent = append(errContent, "name is too short")
-	case len(e.Name) > 50:
-		errContent = append(errContent, "name is too long")
-	case !regexp.MustCompile(namePattern).MatchString(e.Name):
-		errContent = append(errContent, "name contains invalid characters")
-	}
-	// Surname
-	switch {
-	case e.Surname == "":
-		errContent = append(errContent, "surname cannot be empty")
-	case len(e.Surname) < 2:
-		errContent = append(errContent, "surname is too short")
-	case len(e.Surname) > 50:
-		errContent = append(errContent, "surname is too long")
-	case !regexp.MustCompile(namePattern).MatchString(e.Surname):
-		errContent = append(errContent, "surname contains invalid characters")
-	}
+	var errs ValidationErrors
+	checkName(&errs, "name", e.Name, true)
+	checkName(&errs, "surname", e.Surname, true)
+	checkName(&errs, "patronymic", e.Patronymic, false)
 	// Age
-	if e.Age < 1 || e.Age > 120 {
-		errContent = append(errContent, "age contains invalid data")
+	if !e.allowPending(e.AgeStatus) && (e.Age < 1 || e.Age > 120) {
+		errs.Add(
+			"age", CodeOutOfRange, "age contains invalid data",
+			map[string]interface{}{"min": 1, "max": 120},
+		)
 	}
 	// Gender
 	switch {
+	case e.allowPending(e.GenderStatus):
 	case e.Gender == "":
-		errContent = append(errContent, "gender cannot be empty")
+		errs.Add("gender", CodeRequired, "gender cannot be empty", nil)
 	case e.Gender != "male" && e.Gender != "female":
-		errContent = append(
-			errContent, `only “male” or “female” gender is available`,
+		errs.Add(
+			"gender", CodeNotAllowed,
+			`only “male” or “female” gender is available`,
+			map[string]interface{}{"allowed": []string{"male", "female"}},
 		)
 	}
 	// Nationality
 	switch {
+	case e.allowPending(e.NationalityStatus):
 	case e.Nationality == "":
-		errContent = append(errContent, "nationality cannot be empty")
+		errs.Add(
+			"nationality", CodeRequired, "nationality cannot be empty", nil,
+		)
 	case !regexp.MustCompile(countryPattern).MatchString(e.Nationality):
-		errContent = append(
-			errContent, `nationality contains invalid data (example: RU, US)`,
+		errs.Add(
+			"nationality", CodeInvalidFormat,
+			`nationality contains invalid data (example: RU, US)`,
+			map[string]interface{}{"format": "ISO 3166-1 alpha-2"},
 		)
 	}
-	if len(errContent) == 0 {
+	return errs.Err()
+}
+
+// The method for enrich messages by age, gender and nationality with
+// the currently selected provider. It fills the model Entry, otherwise
+// return an error. The lookups are aborted when the context is done.
+func (e *Entry) Enrich(ctx context.Context) error {
+	return e.EnrichWith(ctx, enrich.Current())
+}
+
+// The method for enrich messages by age, gender and nationality with
+// the given provider. It fills the model Entry, otherwise return an
+// error. In the partial mode the fields that were not found are marked
+// as pending instead.
+func (e *Entry) EnrichWith(ctx context.Context, p enrich.Enricher) error {
+	return e.enrichFields(ctx, p, nil)
+}
+
+// The method for enrich the pending fields with the currently selected
+// provider. The fields that were not found stay pending. The overridden
+// fields are never enriched.
+func (e *Entry) EnrichPending(ctx context.Context) error {
+	pending := e.Pending()
+	if len(pending) == 0 {
 		return nil
 	}
-	err := strings.Join(errContent, ", ")
-	return errors.New(err)
+	return e.enrichFields(ctx, enrich.Current(), pending)
 }
 
-// The method for enrich messages by age, gender and
-// nationality. It fills the model Entry from API, otherwise return an
-// error.
-func (e *Entry) Enrich(name string) error {
+func (e *Entry) enrichFields(
+	ctx context.Context, p enrich.Enricher, fields []enrich.Field,
+) error {
 	f := logging.F()
-	errCh := make(chan error, 3)
-	var tasks sync.WaitGroup
-	tasks.Add(3)
-	go requests.Age(name, &e.Age, &tasks, errCh)
-	go requests.Gender(name, &e.Gender, &tasks, errCh)
-	go requests.Nationality(name, &e.Nationality, &tasks, errCh)
-	go func() {
-		tasks.Wait()
-		close(errCh)
-	}()
-	for err := range errCh {
+	fields = e.notOverridden(fields)
+	if len(fields) == 0 {
+		return nil
+	}
+	q := e.query(fields)
+	res, err := p.Enrich(ctx, q)
+	failed := map[enrich.Field]bool{}
+	var partialErr *enrich.PartialError
+	switch {
+	case err == nil:
+	case PartialMode && ctx.Err() == nil && errors.As(err, &partialErr):
+		log.Warn(f+"partially enriched data from API: ", err)
+		for _, field := range partialErr.Failed() {
+			failed[field] = true
+		}
+	default:
 		log.Error(f+"failed to enrich data from API: ", err)
 		return err
 	}
+	for _, field := range q.Wanted() {
+		if failed[field] {
+			e.SetStatus(field, StatusPending)
+			continue
+		}
+		e.apply(field, res)
+		e.SetStatus(field, StatusOK)
+	}
+	return nil
+}
+
+// The method copies the enriched field from the result along with its
+// provenance.
+func (e *Entry) apply(field enrich.Field, res enrich.Result) {
+	if p, ok := res.Provenance[field]; ok {
+		e.Provenance = append(e.Provenance, Provenance{
+			EntryID:    e.ID,
+			Field:      string(field),
+			Provider:   p.Provider,
+			URL:        p.URL,
+			Response:   string(p.Response),
+			Confidence: p.Confidence,
+			CreatedAt:  p.Time,
+		})
+	}
+	switch field {
+	case enrich.FieldAge:
+		e.Age = res.Age
+		e.AgeSource = res.AgeSource
+		e.AgeCountry = res.AgeCountry
+	case enrich.FieldGender:
+		e.Gender = res.Gender
+		e.GenderSource = res.GenderSource
+		e.GenderCountry = res.GenderCountry
+		e.GenderProbability = res.GenderProbability
+		e.GenderCount = res.GenderCount
+		e.GenderConflict = res.GenderConflict
+	case enrich.FieldNationality:
+		e.Nationality = res.Nationality
+		e.NationalitySource = res.NationalitySource
+		e.NationalityProbability = res.NationalityProbability
+		e.NationalityNameProbability = res.NationalityNameProbability
+		e.NationalitySurnameProbability = res.NationalitySurnameProbability
+		e.Candidates = []NationalityCandidate{}
+		for _, country := range res.Countries {
+			e.Candidates = append(e.Candidates, NationalityCandidate{
+				EntryID:            e.ID,
+				CountryID:          country.CountryID,
+				Probability:        country.Probability,
+				NameProbability:    country.NameProbability,
+				SurnameProbability: country.SurnameProbability,
+			})
+		}
+	}
+	e.EnrichMode = enrich.ModeOf(e.AgeCountry, e.GenderCountry)
+}
+
+// The method returns the lookup of the fields. In the localized mode
+// the age and gender are localized to the known nationality if it is
+// not looked up again.
+func (e *Entry) query(fields []enrich.Field) enrich.Query {
+	q := enrich.Query{
+		Name:       e.Name,
+		Surname:    e.Surname,
+		Patronymic: e.Patronymic,
+		Fields:     fields,
+	}
+	if enrich.Mode == enrich.ModeLocalized &&
+		!q.Wants(enrich.FieldNationality) &&
+		e.Status(enrich.FieldNationality) == StatusOK {
+		q.CountryID = e.Nationality
+	}
+	return q
+}
+
+// The method returns the value of the field as a string.
+func (e *Entry) Value(field enrich.Field) string {
+	switch field {
+	case enrich.FieldAge:
+		return fmt.Sprint(e.Age)
+	case enrich.FieldGender:
+		return e.Gender
+	case enrich.FieldNationality:
+		return e.Nationality
+	}
+	return ""
+}
+
+// The method returns the provider the field was taken from.
+func (e *Entry) Source(field enrich.Field) string {
+	switch field {
+	case enrich.FieldAge:
+		return e.AgeSource
+	case enrich.FieldGender:
+		return e.GenderSource
+	case enrich.FieldNationality:
+		return e.NationalitySource
+	}
+	return ""
+}
+
+// The method marks the field as set by hand: the field is fully
+// trusted, overridden since now and its manual provenance is recorded.
+// The manual nationality replaces the candidates, so it is set before.
+func (e *Entry) SetManual(field enrich.Field) {
+	now := time.Now()
+	switch field {
+	case enrich.FieldAge:
+		e.AgeSource = SourceManual
+		e.AgeOverride = &now
+		e.AgeCountry = ""
+	case enrich.FieldGender:
+		e.GenderSource = SourceManual
+		e.GenderOverride = &now
+		e.GenderCountry = ""
+		e.GenderProbability = 1
+		e.GenderCount = 0
+		e.GenderConflict = false
+	case enrich.FieldNationality:
+		e.NationalitySource = SourceManual
+		e.NationalityOverride = &now
+		e.NationalityProbability = 1
+		e.NationalityNameProbability = 0
+		e.NationalitySurnameProbability = 0
+		e.Candidates = []NationalityCandidate{{
+			EntryID:     e.ID,
+			CountryID:   e.Nationality,
+			Probability: 1,
+		}}
+	}
+	e.EnrichMode = enrich.ModeOf(e.AgeCountry, e.GenderCountry)
+	e.SetStatus(field, StatusOK)
+	e.Provenance = append(e.Provenance, Provenance{
+		EntryID:    e.ID,
+		Field:      string(field),
+		Provider:   SourceManual,
+		Confidence: 1,
+		CreatedAt:  now,
+	})
+}
+
+// The method removes the override of the field, so that it is enriched
+// again. The manual candidate of the nationality is removed as well.
+func (e *Entry) ClearOverride(field enrich.Field) {
+	switch field {
+	case enrich.FieldAge:
+		e.AgeOverride = nil
+	case enrich.FieldGender:
+		e.GenderOverride = nil
+	case enrich.FieldNationality:
+		e.NationalityOverride = nil
+		e.Candidates = []NationalityCandidate{}
+	}
+}
+
+// The method reports whether the field is set by hand.
+func (e *Entry) Overridden(field enrich.Field) bool {
+	switch field {
+	case enrich.FieldAge:
+		return e.AgeOverride != nil
+	case enrich.FieldGender:
+		return e.GenderOverride != nil
+	case enrich.FieldNationality:
+		return e.NationalityOverride != nil
+	}
+	return false
+}
+
+// The method returns the fields set by hand. The enrichment never
+// changes them.
+func (e *Entry) Overrides() []enrich.Field {
+	var list []enrich.Field
+	for _, field := range enrich.Fields {
+		if e.Overridden(field) {
+			list = append(list, field)
+		}
+	}
+	return list
+}
+
+// The method saves the overrides of the fields.
+func (e *Entry) SaveOverrides(tx *gorm.DB) error {
+	return tx.Model(&Entry{}).
+		Where("id = ?", e.ID).
+		Updates(map[string]interface{}{
+			"age_override":         e.AgeOverride,
+			"gender_override":      e.GenderOverride,
+			"nationality_override": e.NationalityOverride,
+		}).
+		Error
+}
+
+// The function returns the fields except the overridden ones, all the
+// fields if the list is empty.
+func (e *Entry) notOverridden(fields []enrich.Field) []enrich.Field {
+	if len(fields) == 0 {
+		fields = enrich.Fields
+	}
+	var list []enrich.Field
+	for _, field := range fields {
+		if !e.Overridden(field) {
+			list = append(list, field)
+		}
+	}
+	return list
+}
+
+// The method looks up the given fields again with the provider, except
+// the overridden ones. The found fields are replaced, the others keep
+// their values and statuses. Returns the refreshed fields, otherwise an
+// error if none of them was found.
+func (e *Entry) Refresh(
+	ctx context.Context, p enrich.Enricher, fields []enrich.Field,
+) ([]enrich.Field, error) {
+	f := logging.F()
+	fields = e.notOverridden(fields)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	q := e.query(fields)
+	res, err := p.Enrich(ctx, q)
+	var partialErr *enrich.PartialError
+	if err != nil && (ctx.Err() != nil || !errors.As(err, &partialErr)) {
+		log.Error(f+"failed to enrich data from API: ", err)
+		return nil, err
+	}
+	var refreshed []enrich.Field
+	for _, field := range fields {
+		if res.Has(field) {
+			e.apply(field, res)
+			e.SetStatus(field, StatusOK)
+			refreshed = append(refreshed, field)
+		}
+	}
+	if len(refreshed) == 0 {
+		return nil, err
+	}
+	if err != nil {
+		log.Warn(f+"partially refreshed data from API: ", err)
+	}
+	return refreshed, nil
+}
+
+// The method returns the enrichment status of the field.
+func (e *Entry) Status(field enrich.Field) string {
+	var status string
+	switch field {
+	case enrich.FieldAge:
+		status = e.AgeStatus
+	case enrich.FieldGender:
+		status = e.GenderStatus
+	case enrich.FieldNationality:
+		status = e.NationalityStatus
+	}
+	if status == "" {
+		return StatusOK
+	}
+	return status
+}
+
+// The method sets the enrichment status of the field.
+func (e *Entry) SetStatus(field enrich.Field, status string) {
+	switch field {
+	case enrich.FieldAge:
+		e.AgeStatus = status
+	case enrich.FieldGender:
+		e.GenderStatus = status
+	case enrich.FieldNationality:
+		e.NationalityStatus = status
+	}
+}
+
+// The method returns the fields waiting for the backfill.
+func (e *Entry) Pending() []enrich.Field {
+	var pending []enrich.Field
+	for _, field := range enrich.Fields {
+		if e.Status(field) == StatusPending {
+			pending = append(pending, field)
+		}
+	}
+	return pending
+}
+
+// The method saves the enriched fields, their statuses and the
+// nationality candidates of the existing Entry.
+func (e *Entry) SaveEnrichment(tx *gorm.DB) error {
+	return tx.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Entry{}).
+			Where("id = ?", e.ID).
+			Updates(map[string]interface{}{
+				"age":                     e.Age,
+				"gender":                  e.Gender,
+				"gender_probability":      e.GenderProbability,
+				"gender_count":            e.GenderCount,
+				"nationality":             e.Nationality,
+				"nationality_probability": e.NationalityProbability,
+				"low_confidence":          e.LowConfidence,
+				"gender_conflict":         e.GenderConflict,
+				"age_status":              e.Status(enrich.FieldAge),
+				"gender_status":           e.Status(enrich.FieldGender),
+				"nationality_status":      e.Status(enrich.FieldNationality),
+				"enrich_attempts":         e.EnrichAttempts,
+				"age_source":              e.AgeSource,
+				"gender_source":           e.GenderSource,
+				"nationality_source":      e.NationalitySource,
+				"age_country":             e.AgeCountry,
+				"gender_country":          e.GenderCountry,
+				"enrich_mode":             e.EnrichMode,
+
+				"nationality_name_probability": e.
+					NationalityNameProbability,
+				"nationality_surname_probability": e.
+					NationalitySurnameProbability,
+			}).
+			Error
+		if err != nil {
+			return err
+		}
+		if err := e.saveProvenance(tx); err != nil {
+			return err
+		}
+		if e.Candidates == nil {
+			return nil
+		}
+		err = tx.Where("entry_id = ?", e.ID).
+			Delete(&NationalityCandidate{}).
+			Error
+		if err != nil || len(e.Candidates) == 0 {
+			return err
+		}
+		for i := range e.Candidates {
+			e.Candidates[i].ID = 0
+			e.Candidates[i].EntryID = e.ID
+		}
+		return tx.Create(&e.Candidates).Error
+	})
+}
+
+// The method adds the provenance records that are not saved yet.
+func (e *Entry) saveProvenance(tx *gorm.DB) error {
+	for i := range e.Provenance {
+		if e.Provenance[i].ID != 0 {
+			continue
+		}
+		e.Provenance[i].EntryID = e.ID
+		if err := tx.Create(&e.Provenance[i]).Error; err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// Reports whether the check of the field is skipped because it waits
+// for the backfill.
+func (e *Entry) allowPending(status string) bool {
+	return PartialMode && status == StatusPending
+}
+
+// The method compares the probabilities of the enriched data with the
+// configured thresholds. In the "reject" mode it returns
+// ValidationErrors with the low confidence fields, otherwise it marks
+// the Entry with the LowConfidence flag.
+func (e *Entry) CheckConfidence() error {
+	var errs ValidationErrors
+	if e.GenderStatus != StatusPending &&
+		e.GenderProbability < MinGenderProbability {
+		errs.Add(
+			"gender", CodeLowConfidence,
+			fmt.Sprintf(
+				"gender probability %.2f is below %.2f",
+				e.GenderProbability, MinGenderProbability,
+			),
+			map[string]interface{}{
+				"probability": e.GenderProbability,
+				"min":         MinGenderProbability,
+			},
+		)
+	}
+	if e.NationalityStatus != StatusPending &&
+		e.NationalityProbability < MinNationalityProbability {
+		errs.Add(
+			"nationality", CodeLowConfidence,
+			fmt.Sprintf(
+				"nationality probability %.2f is below %.2f",
+				e.NationalityProbability, MinNationalityProbability,
+			),
+			map[string]interface{}{
+				"probability": e.NationalityProbability,
+				"min":         MinNationalityProbability,
+			},
+		)
+	}
+	e.LowConfidence = len(errs) != 0
+	if !e.LowConfidence || ConfidenceMode != "reject" {
+		return nil
+	}
+	return errs
+}
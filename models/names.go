@@ -0,0 +1,111 @@
+package models
+
+import (
+	"people2/config"
+	"strings"
+	"unicode"
+)
+
+var (
+	// The limits of the name part length in letters.
+	NameMinLength = config.Int("NAME_MIN_LENGTH", 2)
+	NameMaxLength = config.Int("NAME_MAX_LENGTH", 50)
+	// The Unicode scripts the letters of the names are allowed from
+	// (example: Latin, Cyrillic, Greek).
+	NameScripts = config.List(
+		"NAME_SCRIPTS", []string{"Latin", "Cyrillic"},
+	)
+	// The characters allowed between the letters of the names: the
+	// hyphen, the apostrophes and the space by default.
+	NameSeparators = config.String("NAME_SEPARATORS", "- '’")
+)
+
+// The function checks the name part of the person. The part consists
+// of the letters of the allowed scripts with their combining marks,
+// separated by single separators inside. The length is counted in
+// letters, so the combining marks and the separators are not counted.
+// The part must not mix the Latin and Cyrillic letters.
+// The empty part is valid if it is not required.
+func checkName(errs *ValidationErrors, field, value string, required bool) {
+	if value == "" {
+		if required {
+			errs.Add(field, CodeRequired, field+" cannot be empty", nil)
+		}
+		return
+	}
+	var letters int
+	var prev rune
+	for i, r := range value {
+		switch {
+		case strings.ContainsRune(NameSeparators, r):
+			if i == 0 || isSeparator(prev) ||
+				i+len(string(r)) == len(value) {
+				errs.Add(
+					field, CodeInvalidSeparator,
+					field+" contains a misplaced separator",
+					map[string]interface{}{"separators": NameSeparators},
+				)
+				return
+			}
+		case unicode.Is(unicode.Mn, r):
+			if i == 0 || isSeparator(prev) {
+				errs.Add(
+					field, CodeInvalidCharacters,
+					field+" contains invalid characters", nil,
+				)
+				return
+			}
+		case unicode.IsLetter(r):
+			if !allowedScript(r) {
+				errs.Add(
+					field, CodeScriptNotAllowed,
+					field+" contains letters of a script not allowed",
+					map[string]interface{}{"scripts": NameScripts},
+				)
+				return
+			}
+			letters++
+		default:
+			errs.Add(
+				field, CodeInvalidCharacters,
+				field+" contains invalid characters", nil,
+			)
+			return
+		}
+		prev = r
+	}
+	switch {
+	case mixedScript(value):
+		errs.Add(
+			field, CodeMixedScript,
+			field+" mixes Latin and Cyrillic letters",
+			map[string]interface{}{"mode": MixedScriptMode},
+		)
+	case letters < NameMinLength:
+		errs.Add(
+			field, CodeTooShort, field+" is too short",
+			map[string]interface{}{"min": NameMinLength},
+		)
+	case letters > NameMaxLength:
+		errs.Add(
+			field, CodeTooLong, field+" is too long",
+			map[string]interface{}{"max": NameMaxLength},
+		)
+	}
+}
+
+// The function reports whether the rune is one of the name separators.
+func isSeparator(r rune) bool {
+	return r != 0 && strings.ContainsRune(NameSeparators, r)
+}
+
+// The function reports whether the letter belongs to one of the allowed
+// scripts. The unknown script names are ignored.
+func allowedScript(r rune) bool {
+	for _, name := range NameScripts {
+		if table, ok := unicode.Scripts[name]; ok && unicode.Is(table, r) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,192 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"people2/config"
+	"strings"
+	"unicode"
+)
+
+// The handling of the name parts that mix the Latin and Cyrillic
+// letters: "reject" or "normalize" to the script of most of the letters.
+var MixedScriptMode = config.String("NAME_MIXED_SCRIPT", "reject")
+
+// The Cyrillic letters that look the same as the Latin ones.
+var toLatin = map[rune]rune{
+	'а': 'a', 'е': 'e', 'о': 'o', 'р': 'p', 'с': 'c', 'у': 'y', 'х': 'x',
+	'і': 'i', 'ј': 'j', 'ѕ': 's', 'ԁ': 'd', 'һ': 'h',
+	'А': 'A', 'В': 'B', 'Е': 'E', 'К': 'K', 'М': 'M', 'Н': 'H', 'О': 'O',
+	'Р': 'P', 'С': 'C', 'Т': 'T', 'У': 'Y', 'Х': 'X', 'І': 'I', 'Ј': 'J',
+	'Ѕ': 'S',
+}
+
+// The Latin letters that look the same as the Cyrillic ones.
+var toCyrillic = map[rune]rune{}
+
+func init() {
+	for cyrillic, latin := range toLatin {
+		toCyrillic[latin] = cyrillic
+	}
+}
+
+// The original spellings of the name parts by their fields.
+type Spellings map[string]string
+
+// The method stores the spellings in the database as JSON.
+func (s Spellings) Value() (driver.Value, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	value, err := json.Marshal(map[string]string(s))
+	return string(value), err
+}
+
+// The method reads the spellings stored in the database as JSON.
+func (s *Spellings) Scan(src interface{}) error {
+	switch value := src.(type) {
+	case nil:
+		*s = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(value), (*map[string]string)(s))
+	case []byte:
+		return json.Unmarshal(value, (*map[string]string)(s))
+	}
+	return fmt.Errorf("invalid spellings: %T", src)
+}
+
+// The method converts the name parts that mix the Latin and Cyrillic
+// letters in the normalize mode. Returns the original spellings of the
+// converted parts, otherwise nil. The parts that can not be converted
+// are left as they are and rejected by IsValid.
+func (e *FullName) Normalize() Spellings {
+	return normalize(map[string]*string{
+		"name":       &e.Name,
+		"surname":    &e.Surname,
+		"patronymic": &e.Patronymic,
+	})
+}
+
+// The method converts the name parts of the Entry the same way as the
+// FullName and records their original spellings in MixedScript.
+func (e *Entry) Normalize() {
+	e.MixedScript = normalize(map[string]*string{
+		"name":       &e.Name,
+		"surname":    &e.Surname,
+		"patronymic": &e.Patronymic,
+	})
+}
+
+// The method sets the name parts of the Entry to the ones of the
+// normalized update. The original spellings of the unchanged parts are
+// kept, the changed parts take their spellings from the update.
+func (e *Entry) Rename(upd *Entry) {
+	spellings := Spellings{}
+	for field, parts := range map[string][2]*string{
+		"name":       {&e.Name, &upd.Name},
+		"surname":    {&e.Surname, &upd.Surname},
+		"patronymic": {&e.Patronymic, &upd.Patronymic},
+	} {
+		source := e.MixedScript
+		if *parts[0] != *parts[1] {
+			source = upd.MixedScript
+			*parts[0] = *parts[1]
+		}
+		if spelling, ok := source[field]; ok {
+			spellings[field] = spelling
+		}
+	}
+	if len(spellings) == 0 {
+		spellings = nil
+	}
+	e.MixedScript = spellings
+}
+
+// The function converts the mixed name parts in place in the normalize
+// mode and returns their original spellings.
+func normalize(parts map[string]*string) Spellings {
+	if MixedScriptMode != "normalize" {
+		return nil
+	}
+	var spellings Spellings
+	for field, value := range parts {
+		if !mixedScript(*value) {
+			continue
+		}
+		converted, ok := unmix(*value)
+		if !ok {
+			continue
+		}
+		if spellings == nil {
+			spellings = Spellings{}
+		}
+		spellings[field] = *value
+		*value = converted
+	}
+	return spellings
+}
+
+// The function reports whether the name part has both the Latin and
+// Cyrillic letters.
+func mixedScript(value string) bool {
+	latin, cyrillic := scriptCounts(value)
+	return latin != 0 && cyrillic != 0
+}
+
+// The function counts the Latin and Cyrillic letters of the name part.
+func scriptCounts(value string) (latin, cyrillic int) {
+	for _, r := range value {
+		switch {
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+		}
+	}
+	return latin, cyrillic
+}
+
+// The function converts the mixed name part to the script of most of
+// its letters with the confusables table, or to the other script if
+// some letter has no look-alike. Reports whether it is converted.
+func unmix(value string) (string, bool) {
+	latin, cyrillic := scriptCounts(value)
+	order := []func() (string, bool){
+		func() (string, bool) {
+			return convert(value, unicode.Cyrillic, toLatin)
+		},
+		func() (string, bool) {
+			return convert(value, unicode.Latin, toCyrillic)
+		},
+	}
+	if cyrillic > latin {
+		order[0], order[1] = order[1], order[0]
+	}
+	for _, try := range order {
+		if converted, ok := try(); ok {
+			return converted, true
+		}
+	}
+	return value, false
+}
+
+// The function replaces the letters of the script with their look-alikes
+// from the table. Reports false if some letter has none.
+func convert(
+	value string, script *unicode.RangeTable, table map[rune]rune,
+) (string, bool) {
+	var b strings.Builder
+	for _, r := range value {
+		if unicode.Is(script, r) {
+			lookalike, ok := table[r]
+			if !ok {
+				return value, false
+			}
+			r = lookalike
+		}
+		b.WriteRune(r)
+	}
+	return b.String(), true
+}
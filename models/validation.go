@@ -0,0 +1,96 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// The machine-readable codes of the validation errors.
+const (
+	CodeRequired          = "required"
+	CodeTooShort          = "too_short"
+	CodeTooLong           = "too_long"
+	CodeInvalidCharacters = "invalid_characters"
+	CodeOutOfRange        = "out_of_range"
+	CodeNotAllowed        = "not_allowed"
+	CodeInvalidFormat     = "invalid_format"
+	CodeLowConfidence     = "low_confidence"
+	CodeInvalid           = "invalid"
+	CodeTooMany           = "too_many"
+	CodeInvalidSeparator  = "invalid_separator"
+	CodeScriptNotAllowed  = "script_not_allowed"
+	CodeMixedScript       = "mixed_script"
+)
+
+// The validation error of a single field. Code is stable and suitable
+// for the clients, Message is the human-readable explanation and Params
+// holds the values the check used, such as the limits.
+type FieldError struct {
+	Field   string                 `json:"field"`
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Params  map[string]interface{} `json:"params,omitempty"`
+}
+
+// The list of the validation errors in the order of the checks.
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	var errContent []string
+	for _, item := range v {
+		errContent = append(errContent, item.Message)
+	}
+	return strings.Join(errContent, ", ")
+}
+
+// The method adds the error of the field.
+func (v *ValidationErrors) Add(
+	field, code, message string, params map[string]interface{},
+) {
+	*v = append(*v, FieldError{
+		Field:   field,
+		Code:    code,
+		Message: message,
+		Params:  params,
+	})
+}
+
+// The method returns the list as an error, nil if it is empty.
+func (v ValidationErrors) Err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
+
+// The function returns the validation error of a single field.
+func Invalid(field, code, message string) ValidationErrors {
+	var v ValidationErrors
+	v.Add(field, code, message, nil)
+	return v
+}
+
+// The method stores the list in the database as JSON.
+func (v ValidationErrors) Value() (driver.Value, error) {
+	if len(v) == 0 {
+		return nil, nil
+	}
+	value, err := json.Marshal([]FieldError(v))
+	return string(value), err
+}
+
+// The method reads the list stored in the database as JSON.
+func (v *ValidationErrors) Scan(src interface{}) error {
+	switch value := src.(type) {
+	case nil:
+		*v = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(value), (*[]FieldError)(v))
+	case []byte:
+		return json.Unmarshal(value, (*[]FieldError)(v))
+	}
+	return fmt.Errorf("invalid validation errors: %T", src)
+}
@@ -0,0 +1,157 @@
+package morph
+
+import (
+	"sort"
+	"strings"
+)
+
+// The sources of the inference.
+const (
+	SourcePatronymic = "patronymic"
+	SourceSurname    = "surname"
+)
+
+// The gender inferred from the name endings.
+type Inference struct {
+	Gender     string
+	Confidence float64
+	Source     string
+	Ending     string
+}
+
+type rule struct {
+	ending     string
+	gender     string
+	confidence float64
+}
+
+// The endings of the Russian patronymics in the Cyrillic and the
+// transliterated forms.
+var patronymicRules = sorted([]rule{
+	{"ович", "male", 0.99},
+	{"евич", "male", 0.99},
+	{"ьич", "male", 0.99},
+	{"ич", "male", 0.97},
+	{"оглы", "male", 0.99},
+	{"овна", "female", 0.99},
+	{"евна", "female", 0.99},
+	{"ична", "female", 0.99},
+	{"инична", "female", 0.99},
+	{"кызы", "female", 0.99},
+	{"ovich", "male", 0.99},
+	{"evich", "male", 0.99},
+	{"ovitch", "male", 0.99},
+	{"evitch", "male", 0.99},
+	{"ich", "male", 0.97},
+	{"ogly", "male", 0.99},
+	{"ovna", "female", 0.99},
+	{"evna", "female", 0.99},
+	{"ichna", "female", 0.99},
+	{"inichna", "female", 0.99},
+	{"kyzy", "female", 0.99},
+})
+
+// The endings of the Russian surnames in the Cyrillic and the
+// transliterated forms. The Cyrillic -ин endings are less certain, the
+// transliterated ones are in latinInRules.
+var surnameRules = sorted([]rule{
+	{"ов", "male", 0.95},
+	{"ев", "male", 0.95},
+	{"ин", "male", 0.85},
+	{"ын", "male", 0.9},
+	{"ский", "male", 0.97},
+	{"цкий", "male", 0.97},
+	{"ской", "male", 0.95},
+	{"цкой", "male", 0.95},
+	{"ова", "female", 0.95},
+	{"ева", "female", 0.95},
+	{"ина", "female", 0.85},
+	{"ына", "female", 0.9},
+	{"ская", "female", 0.97},
+	{"цкая", "female", 0.97},
+	{"ov", "male", 0.95},
+	{"ev", "male", 0.95},
+	{"off", "male", 0.9},
+	{"yn", "male", 0.85},
+	{"sky", "male", 0.97},
+	{"skiy", "male", 0.97},
+	{"skii", "male", 0.97},
+	{"skij", "male", 0.97},
+	{"tsky", "male", 0.97},
+	{"skoy", "male", 0.95},
+	{"ova", "female", 0.95},
+	{"eva", "female", 0.95},
+	{"yna", "female", 0.85},
+	{"skaya", "female", 0.97},
+	{"skaja", "female", 0.97},
+	{"skaia", "female", 0.97},
+	{"tskaya", "female", 0.97},
+})
+
+// The transliterated -in endings of the Russian surnames. They are
+// common in the other languages too (Martin, Austin, Lina), so they are
+// matched only along with a patronymic.
+var latinInRules = sorted([]rule{
+	{"in", "male", 0.8},
+	{"ina", "female", 0.8},
+})
+
+// The function orders the rules by the ending length, the longest
+// ending is matched first.
+func sorted(rules []rule) []rule {
+	sort.SliceStable(rules, func(i, j int) bool {
+		return len([]rune(rules[i].ending)) > len([]rune(rules[j].ending))
+	})
+	return rules
+}
+
+// The function infers the gender from the patronymic, or from the
+// surname if the patronymic is empty or unknown. If both are known and
+// disagree, the patronymic wins with the lower confidence.
+func Gender(surname, patronymic string) (Inference, bool) {
+	byPatronymic, okPatronymic := match(
+		patronymic, patronymicRules, SourcePatronymic,
+	)
+	bySurname, okSurname := match(surname, surnameRules, SourceSurname)
+	if !okSurname && strings.TrimSpace(patronymic) != "" {
+		bySurname, okSurname = match(surname, latinInRules, SourceSurname)
+	}
+	switch {
+	case okPatronymic && okSurname &&
+		byPatronymic.Gender != bySurname.Gender:
+		byPatronymic.Confidence *= 1 - bySurname.Confidence/2
+		return byPatronymic, true
+	case okPatronymic:
+		return byPatronymic, true
+	case okSurname:
+		return bySurname, true
+	}
+	return Inference{}, false
+}
+
+// The function infers the gender from the patronymic only.
+func Patronymic(patronymic string) (Inference, bool) {
+	return match(patronymic, patronymicRules, SourcePatronymic)
+}
+
+func match(word string, rules []rule, source string) (Inference, bool) {
+	word = normalize(word)
+	for _, r := range rules {
+		// The ending must leave a stem of at least two letters
+		if strings.HasSuffix(word, r.ending) &&
+			len([]rune(word))-len([]rune(r.ending)) >= 2 {
+			return Inference{
+				Gender:     r.gender,
+				Confidence: r.confidence,
+				Source:     source,
+				Ending:     r.ending,
+			}, true
+		}
+	}
+	return Inference{}, false
+}
+
+func normalize(word string) string {
+	word = strings.ToLower(strings.TrimSpace(word))
+	return strings.ReplaceAll(word, "ё", "е")
+}
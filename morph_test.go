@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"people2/enrich"
+	"people2/morph"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing the gender inference in the morph package.
+func TestMorphGender(t *testing.T) {
+	tests := []struct {
+		test       string
+		surname    string
+		patronymic string
+		gender     string
+		source     string
+	}{
+		{
+			test:       "Cyrillic male patronymic",
+			surname:    "Петров",
+			patronymic: "Иванович",
+			gender:     "male",
+			source:     "patronymic",
+		},
+		{
+			test:       "Cyrillic female patronymic",
+			surname:    "",
+			patronymic: "Ильинична",
+			gender:     "female",
+			source:     "patronymic",
+		},
+		{
+			test:       "Transliterated patronymic",
+			surname:    "",
+			patronymic: "Sergeevna",
+			gender:     "female",
+			source:     "patronymic",
+		},
+		{
+			test:       "Cyrillic female surname",
+			surname:    "Ёлкина",
+			patronymic: "",
+			gender:     "female",
+			source:     "surname",
+		},
+		{
+			test:       "Transliterated male surname",
+			surname:    "Dostoevsky",
+			patronymic: "",
+			gender:     "male",
+			source:     "surname",
+		},
+		{
+			test:       "Adjective surname without known ending",
+			surname:    "Tolstaya",
+			patronymic: "",
+			gender:     "",
+			source:     "",
+		},
+		{
+			test:       "Female surname -skaya",
+			surname:    "Kowalskaya",
+			patronymic: "",
+			gender:     "female",
+			source:     "surname",
+		},
+		{
+			test:       "Patronymic wins over surname",
+			surname:    "Ivanova",
+			patronymic: "Petrovich",
+			gender:     "male",
+			source:     "patronymic",
+		},
+		{
+			test:       "Transliterated -in surname alone",
+			surname:    "Martin",
+			patronymic: "",
+			gender:     "",
+			source:     "",
+		},
+		{
+			test:       "Transliterated -ina surname with patronymic",
+			surname:    "Karenina",
+			patronymic: "Foma",
+			gender:     "female",
+			source:     "surname",
+		},
+		{
+			test:       "Unknown endings",
+			surname:    "Smith",
+			patronymic: "",
+			gender:     "",
+			source:     "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.test, func(t *testing.T) {
+			inference, ok := morph.Gender(tt.surname, tt.patronymic)
+			assert.Equal(t, tt.gender != "", ok)
+			assert.Equal(t, tt.gender, inference.Gender)
+			assert.Equal(t, tt.source, inference.Source)
+		})
+	}
+}
+
+// Testing the conflict of the provider gender with the patronymic.
+func TestGenderRulesConflict(t *testing.T) {
+	provider := enrich.Func(
+		func(ctx context.Context, q enrich.Query) (enrich.Result, error) {
+			return enrich.Result{Gender: "female"}, nil
+		},
+	)
+	res, err := enrich.WithRules(provider, "fallback").Enrich(
+		context.Background(),
+		enrich.Query{
+			Name:       "Sasha",
+			Surname:    "Ivanov",
+			Patronymic: "Petrovich",
+		},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "female", res.Gender)
+	assert.True(t, res.GenderConflict)
+}
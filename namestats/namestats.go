@@ -0,0 +1,214 @@
+package namestats
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The header of the dataset file. Countries are separated by "|" with
+// the probability after ":" (example: RU:0.62|UA:0.15).
+var Header = []string{
+	"name", "age", "gender", "gender_probability", "gender_count",
+	"countries",
+}
+
+var countryPattern = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// The statistics of a name. The zero age and the empty gender mean
+// that the data is unknown.
+type Record struct {
+	Name              string
+	Age               uint8
+	Gender            string
+	GenderProbability float64
+	GenderCount       int
+	Countries         []Country
+}
+
+// The nationality candidate of a name.
+type Country struct {
+	CountryID   string
+	Probability float64
+}
+
+// The name statistics keyed by the normalized name.
+type Dataset map[string]Record
+
+// Returns the name in the canonical form of the dataset keys.
+func Normalize(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// The function reads the dataset file.
+func Load(path string) (Dataset, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Read(file)
+}
+
+// The function parses the CSV dataset with the header, otherwise
+// returns an error with the line of the invalid row.
+func Read(r io.Reader) (Dataset, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = len(Header)
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	data := Dataset{}
+	for i, row := range rows {
+		if i == 0 && strings.EqualFold(row[0], Header[0]) {
+			continue
+		}
+		record, err := parse(row)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		data[Normalize(record.Name)] = record
+	}
+	return data, nil
+}
+
+func parse(row []string) (Record, error) {
+	record := Record{Name: strings.TrimSpace(row[0])}
+	if record.Name == "" {
+		return record, fmt.Errorf("name cannot be empty")
+	}
+	if row[1] != "" {
+		age, err := strconv.ParseUint(row[1], 10, 8)
+		if err != nil || age > 120 {
+			return record, fmt.Errorf("invalid age %q", row[1])
+		}
+		record.Age = uint8(age)
+	}
+	record.Gender = strings.ToLower(row[2])
+	if record.Gender != "" && record.Gender != "male" &&
+		record.Gender != "female" {
+		return record, fmt.Errorf("invalid gender %q", row[2])
+	}
+	var err error
+	if record.GenderProbability, err = probability(row[3]); err != nil {
+		return record, err
+	}
+	if row[4] != "" {
+		record.GenderCount, err = strconv.Atoi(row[4])
+		if err != nil || record.GenderCount < 0 {
+			return record, fmt.Errorf("invalid gender count %q", row[4])
+		}
+	}
+	for _, item := range strings.Split(row[5], "|") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		id, value, _ := strings.Cut(item, ":")
+		id = strings.ToUpper(strings.TrimSpace(id))
+		if !countryPattern.MatchString(id) {
+			return record, fmt.Errorf("invalid country %q", item)
+		}
+		p, err := probability(value)
+		if err != nil {
+			return record, err
+		}
+		record.Countries = append(record.Countries, Country{id, p})
+	}
+	sort.SliceStable(record.Countries, func(i, j int) bool {
+		return record.Countries[i].Probability >
+			record.Countries[j].Probability
+	})
+	return record, nil
+}
+
+func probability(value string) (float64, error) {
+	if value = strings.TrimSpace(value); value == "" {
+		return 0, nil
+	}
+	p, err := strconv.ParseFloat(value, 64)
+	if err != nil || p < 0 || p > 1 {
+		return 0, fmt.Errorf("invalid probability %q", value)
+	}
+	return p, nil
+}
+
+// The method writes the dataset as CSV sorted by the name.
+func (d Dataset) Write(w io.Writer) error {
+	keys := make([]string, 0, len(d))
+	for key := range d {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	writer := csv.NewWriter(w)
+	writer.Write(Header)
+	for _, key := range keys {
+		record := d[key]
+		var countries []string
+		for _, country := range record.Countries {
+			countries = append(countries, fmt.Sprintf(
+				"%s:%s",
+				country.CountryID,
+				strconv.FormatFloat(country.Probability, 'f', -1, 64),
+			))
+		}
+		age := ""
+		if record.Age != 0 {
+			age = strconv.Itoa(int(record.Age))
+		}
+		writer.Write([]string{
+			record.Name,
+			age,
+			record.Gender,
+			strconv.FormatFloat(record.GenderProbability, 'f', -1, 64),
+			strconv.Itoa(record.GenderCount),
+			strings.Join(countries, "|"),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// The method replaces the dataset file, the file is never left half
+// written.
+func (d Dataset) Save(path string) error {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".namestats-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	err = d.Write(tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// The method adds the records of the other dataset, replacing the
+// records of the same names. Returns the number of the added and the
+// updated records.
+func (d Dataset) Merge(other Dataset) (added, updated int) {
+	for key, record := range other {
+		if _, ok := d[key]; ok {
+			updated++
+		} else {
+			added++
+		}
+		d[key] = record
+	}
+	return added, updated
+}
@@ -0,0 +1,185 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	db "people2/database"
+	"people2/logging"
+	"people2/models"
+	"people2/requests"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var log = logging.Config
+
+// The stages of the pipeline.
+const (
+	StageValidation = "validation"
+	StageEnrichment = "enrichment"
+	StageFilling    = "filling"
+	StageConfidence = "confidence"
+	StageSaving     = "saving"
+)
+
+// The failure of a pipeline stage with the HTTP status code suggested
+// for it and the message for the client. Errors holds the invalid
+// fields of the validation stages.
+type Error struct {
+	Stage      string
+	Code       int
+	Message    string
+	RetryAfter time.Time
+	Errors     models.ValidationErrors
+	Err        error
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// The function normalizes and validates the message, enriches and
+// checks the Entry without saving it. Returns the Entry, otherwise an
+// Error of the failed stage.
+func Prepare(ctx context.Context, dataMsg models.FullName) (
+	*models.Entry, error,
+) {
+	f := logging.F()
+	spellings := dataMsg.Normalize()
+	if spellings != nil {
+		log.Debug(f+"mixed script normalized: ", spellings)
+	}
+	err := dataMsg.IsValid()
+	if err != nil {
+		log.Debug(f+"invalid message: ", err)
+		return nil, invalid(StageValidation, err)
+	}
+	entry := &models.Entry{
+		Name:        dataMsg.Name,
+		Surname:     dataMsg.Surname,
+		Patronymic:  dataMsg.Patronymic,
+		MixedScript: spellings,
+	}
+	err = entry.Enrich(ctx)
+	if err != nil {
+		return nil, enrichError(err)
+	}
+	log.WithFields(logrus.Fields{
+		"Name":        entry.Name,
+		"Surname":     entry.Surname,
+		"Patronymic":  entry.Patronymic,
+		"Age":         entry.Age,
+		"Gender":      entry.Gender,
+		"Nationality": entry.Nationality,
+		"GenderP":     entry.GenderProbability,
+		"CountryP":    entry.NationalityProbability,
+	}).Debug(f + "entry")
+	err = entry.IsValid()
+	if err != nil {
+		return entry, invalid(StageFilling, err)
+	}
+	err = entry.CheckConfidence()
+	if err != nil {
+		log.Debug(f+"low confidence data: ", err)
+		return entry, invalid(StageConfidence, err)
+	}
+	return entry, nil
+}
+
+// The function validates the message, enriches, checks and saves the
+// Entry to the database. Returns the saved Entry, otherwise an Error of
+// the failed stage.
+func Process(ctx context.Context, dataMsg models.FullName) (
+	*models.Entry, error,
+) {
+	f := logging.F()
+	entry, err := Prepare(ctx, dataMsg)
+	if err != nil {
+		return entry, err
+	}
+	err = db.C.Create(entry).Error
+	if err != nil {
+		log.Error(f+"failed to create entry: ", err)
+		return entry, &Error{
+			Stage:   StageSaving,
+			Code:    500,
+			Message: "Failed to create entry",
+			Err:     err,
+		}
+	}
+	return entry, nil
+}
+
+// The function converts the validation error of the stage to an Error
+// with the invalid fields.
+func invalid(stage string, err error) *Error {
+	perr := &Error{Stage: stage, Code: 422, Message: err.Error(), Err: err}
+	errors.As(err, &perr.Errors)
+	switch stage {
+	case StageFilling:
+		perr.Message = fmt.Sprintf("Filling errors: %v", err)
+	case StageConfidence:
+		perr.Message = fmt.Sprintf("Low confidence: %v", err)
+	}
+	return perr
+}
+
+// The function converts the enrichment error to an Error with the
+// status code of its cause.
+func enrichError(err error) *Error {
+	f := logging.F()
+	var statusErr *requests.StatusError
+	var breakerErr *requests.BreakerError
+	var quotaErr *requests.QuotaError
+	var limitErr *requests.LimitError
+	perr := &Error{Stage: StageEnrichment, Err: err}
+	switch {
+	case errors.Is(err, context.Canceled):
+		log.Debug(f+"request cancelled by client: ", err)
+		perr.Code = 499
+		perr.Message = "Request cancelled"
+	case errors.Is(err, context.DeadlineExceeded):
+		log.Error(f+"enrichment API timed out: ", err)
+		perr.Code = 504
+		perr.Message = "Enrichment API timed out"
+	case errors.As(err, &quotaErr):
+		log.Warn(f+"enrichment API quota exhausted: ", err)
+		perr.Code = 429
+		perr.Message = fmt.Sprintf(
+			"Enrichment API %s quota exhausted until %s",
+			quotaErr.Provider,
+			quotaErr.Until.Format(time.RFC3339),
+		)
+		perr.RetryAfter = quotaErr.Until
+	case errors.As(err, &breakerErr):
+		log.Warn(f+"enrichment API is unavailable: ", err)
+		perr.Code = 503
+		perr.Message = fmt.Sprintf(
+			"Enrichment API %s is temporarily unavailable",
+			breakerErr.Provider,
+		)
+		perr.RetryAfter = breakerErr.Until
+	case errors.As(err, &limitErr):
+		log.Warn(f+"enrichment API calls are over the limit: ", err)
+		perr.Code = 503
+		perr.Message = "Enrichment API is busy, try again later"
+		perr.RetryAfter = time.Now().Add(limitErr.Wait)
+	case errors.As(err, &statusErr):
+		log.Error(f+"enrichment API responded with error: ", err)
+		perr.Code = 502
+		perr.Message = fmt.Sprintf(
+			"Enrichment API responded with status %d", statusErr.Code,
+		)
+	default:
+		log.Error(f+"failed to enrich data from API: ", err)
+		perr.Code = 500
+		perr.Message = fmt.Sprintf("Failed to enrich data from API: %v", err)
+	}
+	return perr
+}
@@ -0,0 +1,102 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"people2/enrich"
+	"people2/logging"
+	"people2/models"
+)
+
+// The preview of the Entry the message would be saved as, with the
+// confidence, the sources and the lookup mode of the enriched fields.
+// MixedScript holds the original spellings of the normalized name
+// parts, Errors holds the causes the message would be rejected for.
+type Preview struct {
+	Name                   string
+	Surname                string
+	Patronymic             string
+	MixedScript            models.Spellings
+	Age                    uint8
+	Gender                 string
+	GenderProbability      float64
+	GenderConflict         bool
+	Nationality            string
+	NationalityProbability float64
+	NameProbability        float64
+	SurnameProbability     float64
+	Candidates             []models.NationalityCandidate
+	Sources                map[enrich.Field]string
+	Mode                   string
+	Pending                []enrich.Field
+	LowConfidence          bool
+	Valid                  bool
+	Errors                 models.ValidationErrors
+}
+
+// The function validates, enriches and checks the message the same way
+// as Prepare, but saves nothing, not even to the enrichment cache. The
+// invalid message is not enriched. Returns the Preview, otherwise an
+// Error of the enrichment.
+func PreviewEntry(ctx context.Context, dataMsg models.FullName) (
+	*Preview, error,
+) {
+	f := logging.F()
+	spellings := dataMsg.Normalize()
+	preview := &Preview{
+		Name:        dataMsg.Name,
+		Surname:     dataMsg.Surname,
+		Patronymic:  dataMsg.Patronymic,
+		MixedScript: spellings,
+	}
+	if err := dataMsg.IsValid(); err != nil {
+		log.Debug(f+"invalid message: ", err)
+		preview.Errors = append(preview.Errors, fieldErrors(err)...)
+		return preview, nil
+	}
+	entry := &models.Entry{
+		Name:        dataMsg.Name,
+		Surname:     dataMsg.Surname,
+		Patronymic:  dataMsg.Patronymic,
+		MixedScript: spellings,
+	}
+	err := entry.Enrich(enrich.DryRun(ctx))
+	if err != nil {
+		return nil, enrichError(err)
+	}
+	if err := entry.IsValid(); err != nil {
+		preview.Errors = append(preview.Errors, fieldErrors(err)...)
+	}
+	if err := entry.CheckConfidence(); err != nil {
+		preview.Errors = append(preview.Errors, fieldErrors(err)...)
+	}
+	preview.Age = entry.Age
+	preview.Gender = entry.Gender
+	preview.GenderProbability = entry.GenderProbability
+	preview.GenderConflict = entry.GenderConflict
+	preview.Nationality = entry.Nationality
+	preview.NationalityProbability = entry.NationalityProbability
+	preview.NameProbability = entry.NationalityNameProbability
+	preview.SurnameProbability = entry.NationalitySurnameProbability
+	preview.Candidates = entry.Candidates
+	preview.Sources = map[enrich.Field]string{}
+	for _, field := range enrich.Fields {
+		if source := entry.Source(field); source != "" {
+			preview.Sources[field] = source
+		}
+	}
+	preview.Mode = entry.EnrichMode
+	preview.Pending = entry.Pending()
+	preview.LowConfidence = entry.LowConfidence
+	preview.Valid = len(preview.Errors) == 0
+	return preview, nil
+}
+
+// The function returns the invalid fields of the validation error.
+func fieldErrors(err error) models.ValidationErrors {
+	var errs models.ValidationErrors
+	if !errors.As(err, &errs) {
+		errs.Add("", models.CodeInvalid, err.Error(), nil)
+	}
+	return errs
+}
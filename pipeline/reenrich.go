@@ -0,0 +1,205 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	db "people2/database"
+	"people2/enrich"
+	"people2/logging"
+	"people2/models"
+	"people2/requests"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// The change of an enriched field with the provider of the new value.
+type Change struct {
+	Field  enrich.Field
+	Old    string
+	New    string
+	Source string
+}
+
+// The result of the re-enrichment of the Entry. Manual holds the fields
+// set by hand that were left as they are, Applied reports whether the
+// changes were saved.
+type Diff struct {
+	EntryID uint
+	Changes []Change
+	Manual  []enrich.Field
+	Applied bool
+}
+
+// The error returned when the Entry is changed while it is re-enriched.
+var errChanged = errors.New("entry was changed during the enrichment")
+
+// The function looks up the fields of the saved Entry again, except the
+// ones set by hand, and checks the result. The cached answers are not
+// used, the providers are asked again. The changes are saved if
+// apply is set, otherwise the Entry is left as it is. Returns the
+// Diff, otherwise an Error of the failed stage.
+func Reenrich(ctx context.Context, entry *models.Entry, apply bool) (
+	*Diff, error,
+) {
+	f := logging.F()
+	diff := &Diff{EntryID: entry.ID, Manual: entry.Overrides()}
+	updated := *entry
+	updated.Candidates = nil
+	updated.Provenance = nil
+	refreshed, err := updated.Refresh(
+		enrich.Fresh(ctx), enrich.Current(), nil,
+	)
+	if err != nil {
+		return nil, enrichError(err)
+	}
+	for _, field := range refreshed {
+		if entry.Value(field) != updated.Value(field) {
+			diff.Changes = append(diff.Changes, Change{
+				Field:  field,
+				Old:    entry.Value(field),
+				New:    updated.Value(field),
+				Source: updated.Source(field),
+			})
+		}
+	}
+	err = updated.IsValid()
+	if err != nil {
+		return diff, invalid(StageFilling, err)
+	}
+	err = updated.CheckConfidence()
+	if err != nil {
+		log.Debug(f+"low confidence data: ", err)
+		return diff, invalid(StageConfidence, err)
+	}
+	if !apply || len(refreshed) == 0 {
+		return diff, nil
+	}
+	err = db.C.Transaction(func(tx *gorm.DB) error {
+		// The fields set by hand in the meantime must not be lost
+		var current models.Entry
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&current, "id = ?", entry.ID).
+			Error
+		if err != nil {
+			return err
+		}
+		for _, field := range enrich.Fields {
+			if current.Value(field) != entry.Value(field) ||
+				current.Overridden(field) != entry.Overridden(field) {
+				return errChanged
+			}
+		}
+		return updated.SaveEnrichment(tx)
+	})
+	switch {
+	case errors.Is(err, errChanged):
+		log.Debug(f+"entry changed during the enrichment: ", entry.ID)
+		return diff, &Error{
+			Stage:   StageSaving,
+			Code:    409,
+			Message: "Entry was changed during the enrichment, try again",
+			Err:     err,
+		}
+	case err != nil:
+		log.Error(f+"failed to save entry: ", err)
+		return diff, &Error{
+			Stage:   StageSaving,
+			Code:    500,
+			Message: "Failed to save entry",
+			Err:     err,
+		}
+	}
+	*entry = updated
+	diff.Applied = true
+	return diff, nil
+}
+
+// The function re-enriches the entries matching the query in batches of
+// the given size and passes every result to fn in order. The entries of
+// a batch are re-enriched concurrently, so that the names are sent to
+// the providers in batches. Stops when the context is done.
+func ReenrichAll(
+	ctx context.Context, query *gorm.DB, batch int, apply bool,
+	fn func(entry *models.Entry, diff *Diff, err error),
+) error {
+	ctx = requests.Bulk(ctx)
+	var entries []models.Entry
+	return query.FindInBatches(
+		&entries, batch,
+		func(tx *gorm.DB, n int) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			diffs := make([]*Diff, len(entries))
+			errs := make([]error, len(entries))
+			var tasks sync.WaitGroup
+			for i := range entries {
+				tasks.Add(1)
+				go func(i int) {
+					defer tasks.Done()
+					diffs[i], errs[i] = Reenrich(ctx, &entries[i], apply)
+				}(i)
+			}
+			tasks.Wait()
+			for i := range entries {
+				fn(&entries[i], diffs[i], errs[i])
+			}
+			return ctx.Err()
+		},
+	).Error
+}
+
+// The function clears the override of the Entry field and looks the
+// field up again. In the partial mode the field that was not found is
+// left pending for the backfill, otherwise the override is kept and an
+// Error of the failed stage is returned.
+func ClearOverride(
+	ctx context.Context, entry *models.Entry, field enrich.Field,
+) error {
+	f := logging.F()
+	updated := *entry
+	updated.Candidates = nil
+	updated.Provenance = nil
+	updated.ClearOverride(field)
+	refreshed, err := updated.Refresh(
+		ctx, enrich.Current(), []enrich.Field{field},
+	)
+	switch {
+	case err == nil && len(refreshed) == 0:
+		err = fmt.Errorf("%s data not found", field)
+		fallthrough
+	case err != nil:
+		if !models.PartialMode || ctx.Err() != nil {
+			return enrichError(err)
+		}
+		log.Warn(f+"field left pending: ", err)
+		updated.SetStatus(field, models.StatusPending)
+	}
+	if err := updated.IsValid(); err != nil {
+		return invalid(StageFilling, err)
+	}
+	if err := updated.CheckConfidence(); err != nil {
+		log.Debug(f+"low confidence data: ", err)
+		return invalid(StageConfidence, err)
+	}
+	err = db.C.Transaction(func(tx *gorm.DB) error {
+		if err := updated.SaveEnrichment(tx); err != nil {
+			return err
+		}
+		return updated.SaveOverrides(tx)
+	})
+	if err != nil {
+		log.Error(f+"failed to save entry: ", err)
+		return &Error{
+			Stage:   StageSaving,
+			Code:    500,
+			Message: "Failed to save entry",
+			Err:     err,
+		}
+	}
+	*entry = updated
+	return nil
+}
@@ -0,0 +1,87 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"people2/logging"
+	"people2/models"
+	"people2/pipeline"
+)
+
+// The consumer of the FIO messages. Every message goes through the same
+// pipeline as the Create handler, the failed ones are published to the
+// failed topic with the cause.
+type Consumer struct {
+	Broker      Broker
+	Topic       string
+	FailedTopic string
+}
+
+// The function starts the consumer of the broker in the background.
+// Nothing is started for the nil broker of the "none" kind.
+func Start(ctx context.Context, broker Broker) {
+	if broker == nil {
+		return
+	}
+	consumer := &Consumer{
+		Broker:      broker,
+		Topic:       Topic,
+		FailedTopic: FailedTopic,
+	}
+	go consumer.Run(ctx)
+}
+
+// The method consumes the topic until the context is done.
+func (c *Consumer) Run(ctx context.Context) error {
+	f := logging.F()
+	log.Infof(f+"consuming %s with %s broker", c.Topic, Kind)
+	err := c.Broker.Consume(ctx, c.Topic, func(msg Message) {
+		c.Handle(ctx, msg)
+	})
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Error(f+"consumer stopped: ", err)
+	}
+	return err
+}
+
+// The method processes a single message.
+func (c *Consumer) Handle(ctx context.Context, msg Message) {
+	f := logging.F()
+	var dataMsg models.FullName
+	err := json.Unmarshal(msg.Payload, &dataMsg)
+	if err != nil {
+		log.Debug(f+"invalid payload: ", err)
+		c.fail(
+			ctx, msg, pipeline.StageValidation, "Invalid payload: "+err.Error(),
+			models.Invalid("payload", models.CodeInvalid, err.Error()),
+		)
+		return
+	}
+	entry, err := pipeline.Process(ctx, dataMsg)
+	var perr *pipeline.Error
+	switch {
+	case err == nil:
+		log.Debugf(f+"message %s saved as entry %d", msg.ID, entry.ID)
+	case errors.As(err, &perr):
+		c.fail(ctx, msg, perr.Stage, perr.Message, perr.Errors)
+	default:
+		c.fail(ctx, msg, "", err.Error(), nil)
+	}
+}
+
+// The method publishes the message with the cause and the invalid
+// fields to the failed topic.
+func (c *Consumer) fail(
+	ctx context.Context, msg Message, stage, cause string,
+	errs models.ValidationErrors,
+) {
+	f := logging.F()
+	msg.Stage = stage
+	msg.Error = cause
+	msg.Errors = errs
+	err := c.Broker.Publish(ctx, c.FailedTopic, msg)
+	if err != nil {
+		log.Error(f+"failed to publish failed message: ", err)
+	}
+}
@@ -0,0 +1,159 @@
+package queue
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The pause between the checks of a topic file for new messages.
+var PollInterval = time.Second
+
+// The file broker for local runs. Every topic is a file of JSON lines
+// in the directory, and the position of the consumer is kept in a
+// neighbouring ".offset" file. The JSON objects that are not messages
+// are delivered as the payload, the other lines as a string payload.
+type File struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// The function creates the file broker in the directory.
+func NewFile(dir string) (*File, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &File{dir: dir}, nil
+}
+
+func (b *File) path(topic string) string {
+	return filepath.Join(b.dir, topic+".jsonl")
+}
+
+func (b *File) Publish(
+	ctx context.Context, topic string, msg Message,
+) error {
+	if msg.Time.IsZero() {
+		msg.Time = time.Now()
+	}
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	file, err := os.OpenFile(
+		b.path(topic), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644,
+	)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+func (b *File) Consume(
+	ctx context.Context, topic string, handler func(Message),
+) error {
+	offsetPath := b.path(topic) + ".offset"
+	offset := readOffset(offsetPath)
+	for {
+		next, err := b.read(topic, offset, handler, func(pos int64) error {
+			return os.WriteFile(
+				offsetPath, []byte(strconv.FormatInt(pos, 10)), 0o644,
+			)
+		})
+		if err != nil {
+			return err
+		}
+		offset = next
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(PollInterval):
+		}
+	}
+}
+
+// The method delivers the complete lines after the offset and returns
+// the new offset.
+func (b *File) read(
+	topic string,
+	offset int64,
+	handler func(Message),
+	commit func(int64) error,
+) (int64, error) {
+	file, err := os.Open(b.path(topic))
+	if os.IsNotExist(err) {
+		return offset, nil
+	}
+	if err != nil {
+		return offset, err
+	}
+	defer file.Close()
+	_, err = file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return offset, err
+	}
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// The incomplete line is read on the next poll
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+		offset += int64(len(line))
+		text := strings.TrimSpace(string(line))
+		if text != "" {
+			handler(parseLine(text, offset))
+		}
+		err = commit(offset)
+		if err != nil {
+			return offset, err
+		}
+	}
+}
+
+// The function reads the message of the line. The line that is a JSON
+// object without the payload is the payload itself, such as a plain FIO
+// message.
+func parseLine(text string, offset int64) Message {
+	var msg Message
+	err := json.Unmarshal([]byte(text), &msg)
+	if err == nil && len(msg.Payload) != 0 {
+		return msg
+	}
+	raw := json.RawMessage(text)
+	if err != nil || !strings.HasPrefix(text, "{") {
+		raw, _ = json.Marshal(text)
+	}
+	return Message{
+		ID:      strconv.FormatInt(offset, 10),
+		Payload: raw,
+		Time:    time.Now(),
+	}
+}
+
+func readOffset(path string) int64 {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	offset, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return offset
+}
@@ -0,0 +1,68 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// The number of the messages a topic of the in-memory broker holds.
+const memoryBuffer = 1024
+
+// The error of publishing to a full topic of the in-memory broker.
+var ErrTopicFull = errors.New("topic is full")
+
+// The in-memory broker. The messages are lost on restart.
+type Memory struct {
+	mu     sync.Mutex
+	topics map[string]chan Message
+}
+
+// The function creates the in-memory broker.
+func NewMemory() *Memory {
+	return &Memory{topics: map[string]chan Message{}}
+}
+
+func (m *Memory) topic(name string) chan Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ch, ok := m.topics[name]
+	if !ok {
+		ch = make(chan Message, memoryBuffer)
+		m.topics[name] = ch
+	}
+	return ch
+}
+
+// The method does not wait for a full topic and returns ErrTopicFull,
+// since the failed topic may have no consumer in the process.
+func (m *Memory) Publish(
+	ctx context.Context, topic string, msg Message,
+) error {
+	if msg.Time.IsZero() {
+		msg.Time = time.Now()
+	}
+	select {
+	case m.topic(topic) <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return ErrTopicFull
+	}
+}
+
+func (m *Memory) Consume(
+	ctx context.Context, topic string, handler func(Message),
+) error {
+	ch := m.topic(topic)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg := <-ch:
+			handler(msg)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"people2/config"
+	"people2/logging"
+	"people2/models"
+	"time"
+)
+
+var (
+	log = logging.Config
+	// The broker kind: "none", "memory" or "file".
+	Kind = config.String("QUEUE_BROKER", "none")
+	// The directory of the file broker topics.
+	Dir = config.String("QUEUE_DIR", "data/queue")
+	// The topic of the incoming FIO messages.
+	Topic = config.String("QUEUE_TOPIC", "people.fio")
+	// The topic of the messages that failed the pipeline.
+	FailedTopic = config.String("QUEUE_FAILED_TOPIC", "people.fio.failed")
+	// The broker of the process created by main, nil for the "none"
+	// kind. The producers of the process publish to it, the memory
+	// broker is only reachable this way.
+	Default Broker
+)
+
+// The message of a topic. Error holds the cause of the failure for the
+// messages of the failed topic, Errors holds the invalid fields.
+type Message struct {
+	ID      string                  `json:"id"`
+	Payload json.RawMessage         `json:"payload"`
+	Error   string                  `json:"error,omitempty"`
+	Errors  models.ValidationErrors `json:"errors,omitempty"`
+	Stage   string                  `json:"stage,omitempty"`
+	Time    time.Time               `json:"time"`
+}
+
+// The interface of the message brokers.
+type Broker interface {
+	// Publish appends the message to the topic.
+	Publish(ctx context.Context, topic string, msg Message) error
+	// Consume passes the messages of the topic to the handler one by one
+	// until the context is done. The message is acknowledged when the
+	// handler returns.
+	Consume(ctx context.Context, topic string, handler func(Message)) error
+}
+
+// The function creates the broker of the given kind, nil for the "none"
+// kind.
+func New(kind string) (Broker, error) {
+	switch kind {
+	case "", "none":
+		return nil, nil
+	case "memory":
+		return NewMemory(), nil
+	case "file":
+		return NewFile(Dir)
+	default:
+		return nil, fmt.Errorf("unknown broker %q", kind)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"people2/queue"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing that publishing to a full topic of the in-memory broker fails
+// instead of blocking.
+func TestMemoryTopicFull(t *testing.T) {
+	broker := queue.NewMemory()
+	ctx := context.Background()
+	var err error
+	for i := 0; i <= 1024 && err == nil; i++ {
+		err = broker.Publish(ctx, "failed", queue.Message{ID: "1"})
+	}
+	assert.ErrorIs(t, err, queue.ErrTopicFull)
+}
+
+// Testing that the file broker delivers a plain JSON line as the payload
+// and quotes the other lines.
+func TestFileLines(t *testing.T) {
+	dir := t.TempDir()
+	lines := `{"Name":"Ivan","Surname":"Ivanov"}` + "\n" +
+		`{"id":"7","payload":{"Name":"Anna"}}` + "\n" +
+		"Ivan Ivanov\n"
+	err := os.WriteFile(
+		filepath.Join(dir, "people.jsonl"), []byte(lines), 0o644,
+	)
+	assert.NoError(t, err)
+
+	broker, err := queue.NewFile(dir)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	var payloads []string
+	broker.Consume(ctx, "people", func(msg queue.Message) {
+		payloads = append(payloads, string(msg.Payload))
+		if len(payloads) == 3 {
+			cancel()
+		}
+	})
+	assert.Equal(t, []string{
+		`{"Name":"Ivan","Surname":"Ivanov"}`,
+		`{"Name":"Anna"}`,
+		`"Ivan Ivanov"`,
+	}, payloads)
+}
@@ -0,0 +1,167 @@
+package requests
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"people2/config"
+	"sync"
+	"time"
+)
+
+var (
+	// The time a name waits for other names before the batch is sent.
+	// Zero disables batching of the single lookups.
+	BatchWindow = config.Duration("REQUESTS_BATCH_WINDOW", 0)
+	// The maximum number of names the providers accept in one call.
+	BatchSize = config.Int("REQUESTS_BATCH_SIZE", 10)
+	// The window of the bulk lookups, used when BatchWindow is zero.
+	BulkWindow = config.Duration(
+		"REQUESTS_BULK_WINDOW", 20*time.Millisecond,
+	)
+)
+
+type bulkKey struct{}
+
+// The function returns the context of the lookups made for many names
+// at once, such as the backfill. Their names are sent in batches even
+// if the single lookups are not batched.
+func Bulk(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bulkKey{}, true)
+}
+
+// The function returns the time the lookup of the context waits for
+// the other names, zero if it is not batched.
+func window(ctx context.Context) time.Duration {
+	if bulk, _ := ctx.Value(bulkKey{}).(bool); BatchWindow == 0 && bulk {
+		return BulkWindow
+	}
+	return BatchWindow
+}
+
+// Obtains the provider data for several names with the name[] query,
+// splitting them into calls of BatchSize names. The extra parameters
+// are sent with every call. Returns the data by the name.
+func Batch(
+	ctx context.Context, p *Provider, names []string, extra url.Values,
+) (map[string]map[string]interface{}, error) {
+	size := BatchSize
+	if size < 1 {
+		size = 1
+	}
+	found := map[string]map[string]interface{}{}
+	for start := 0; start < len(names); start += size {
+		end := start + size
+		if end > len(names) {
+			end = len(names)
+		}
+		chunk := names[start:end]
+		query := url.Values{}
+		for key, list := range extra {
+			query[key] = list
+		}
+		query["name[]"] = chunk
+		var list []map[string]interface{}
+		err := apiReq(ctx, p, query, &list)
+		if err != nil {
+			return found, err
+		}
+		for i, item := range list {
+			name, ok := item["name"].(string)
+			if !ok && i < len(chunk) {
+				name = chunk[i]
+			}
+			found[name] = item
+		}
+	}
+	return found, nil
+}
+
+// The pending names of a provider grouped by the extra parameters.
+type batcher struct {
+	mu     sync.Mutex
+	groups map[string]*batch
+}
+
+type batch struct {
+	p       *Provider
+	extra   url.Values
+	names   []string
+	waiters map[string][]chan batchResult
+}
+
+type batchResult struct {
+	data map[string]interface{}
+	err  error
+}
+
+func newBatcher() *batcher {
+	return &batcher{groups: map[string]*batch{}}
+}
+
+// The method adds the name to the pending batch and waits for its data.
+// The batch is sent when it is full or the window of the first name is
+// over. A cancelled caller stops waiting, but the batch is still sent
+// for the others.
+func (b *batcher) add(
+	ctx context.Context, p *Provider, name string, extra url.Values,
+	window time.Duration,
+) (map[string]interface{}, error) {
+	ch := make(chan batchResult, 1)
+	key := extra.Encode()
+	b.mu.Lock()
+	g, ok := b.groups[key]
+	if !ok {
+		g = &batch{
+			p:       p,
+			extra:   extra,
+			waiters: map[string][]chan batchResult{},
+		}
+		b.groups[key] = g
+		time.AfterFunc(window, func() { b.flush(key, g) })
+	}
+	if _, ok := g.waiters[name]; !ok {
+		g.names = append(g.names, name)
+	}
+	g.waiters[name] = append(g.waiters[name], ch)
+	if len(g.names) >= BatchSize {
+		delete(b.groups, key)
+		go b.send(g)
+	}
+	b.mu.Unlock()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		return res.data, res.err
+	}
+}
+
+// The method sends the batch if it was not sent yet.
+func (b *batcher) flush(key string, g *batch) {
+	b.mu.Lock()
+	if b.groups[key] != g {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.groups, key)
+	b.mu.Unlock()
+	b.send(g)
+}
+
+// The method calls the provider and fans the data out to the waiters.
+func (b *batcher) send(g *batch) {
+	found, err := Batch(context.Background(), g.p, g.names, g.extra)
+	for name, waiters := range g.waiters {
+		res := batchResult{data: found[name], err: err}
+		switch {
+		case res.data != nil:
+			res.err = nil
+		case res.err == nil:
+			res.err = fmt.Errorf("%s data not found for %q", g.p.Name, name)
+		}
+		for _, ch := range waiters {
+			ch <- res
+		}
+	}
+}
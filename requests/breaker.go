@@ -0,0 +1,163 @@
+package requests
+
+import (
+	"fmt"
+	"people2/config"
+	"sync"
+	"time"
+)
+
+var (
+	// The number of consecutive failures that opens the breaker.
+	BreakerThreshold = config.Int("BREAKER_THRESHOLD", 5)
+	// The time the breaker stays open before a trial call.
+	BreakerCooldown = config.Duration("BREAKER_COOLDOWN", 30*time.Second)
+)
+
+// The state of a circuit breaker.
+type State int
+
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// The error returned without calling the provider while its breaker is
+// open.
+type BreakerError struct {
+	Provider string
+	Until    time.Time
+}
+
+func (e *BreakerError) Error() string {
+	return fmt.Sprintf(
+		"provider %s is unavailable until %s",
+		e.Provider, e.Until.Format(time.RFC3339),
+	)
+}
+
+// The snapshot of a circuit breaker.
+type BreakerStatus struct {
+	State    State     `json:"state"`
+	Failures int       `json:"failures"`
+	Until    time.Time `json:"until,omitempty"`
+}
+
+// The per-provider circuit breaker. It opens after Threshold
+// consecutive failures, fails fast for Cooldown, then lets one trial
+// call through and closes again if it succeeds.
+type Breaker struct {
+	Name      string
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	until    time.Time
+	trial    bool
+}
+
+// The function creates the breaker with the configured settings.
+func NewBreaker(name string) *Breaker {
+	return &Breaker{
+		Name:      name,
+		Threshold: BreakerThreshold,
+		Cooldown:  BreakerCooldown,
+	}
+}
+
+// The method checks whether a call may be sent, otherwise returns a
+// BreakerError.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case Open:
+		if time.Now().Before(b.until) {
+			return &BreakerError{Provider: b.Name, Until: b.until}
+		}
+		b.setState(HalfOpen)
+		b.trial = true
+		return nil
+	case HalfOpen:
+		if b.trial {
+			return &BreakerError{Provider: b.Name, Until: b.until}
+		}
+		b.trial = true
+	}
+	return nil
+}
+
+// The method records a successful call.
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.trial = false
+	b.setState(Closed)
+}
+
+// The method records a failed call.
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.trial = false
+	if b.state == HalfOpen || b.failures >= b.Threshold {
+		b.until = time.Now().Add(b.Cooldown)
+		b.setState(Open)
+	}
+}
+
+// The method ends the call without recording its outcome, such as the
+// call given up by the caller. The trial call may be sent again.
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// The method returns the current state of the breaker.
+func (b *Breaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := BreakerStatus{State: b.state, Failures: b.failures}
+	if b.state != Closed {
+		status.Until = b.until
+	}
+	return status
+}
+
+func (b *Breaker) setState(state State) {
+	if b.state == state {
+		return
+	}
+	log.Warnf("Breaker %s: %v -> %v", b.Name, b.state, state)
+	b.state = state
+}
+
+// The function returns the breaker states of all providers.
+func Breakers() map[string]BreakerStatus {
+	states := map[string]BreakerStatus{}
+	for _, p := range Providers() {
+		states[p.Name] = p.Breaker.Status()
+	}
+	return states
+}
@@ -0,0 +1,72 @@
+package requests
+
+import (
+	"context"
+	"sync"
+)
+
+// The lookup shared by the callers of the same key.
+type flight struct {
+	done    chan struct{}
+	data    map[string]interface{}
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// The group of the in-flight lookups by their keys.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+var flights = &flightGroup{flights: map[string]*flight{}}
+
+// The method calls fn once for all the concurrent callers of the same
+// key and returns its result to each of them. The call is not bound to
+// the deadline of any caller: a caller that gives up stops waiting, and
+// the call is cancelled when all of them gave up. The cancelled call is
+// forgotten at once, so the next caller of the key starts a new one.
+func (g *flightGroup) do(
+	ctx context.Context, key string,
+	fn func(ctx context.Context) (map[string]interface{}, error),
+) (map[string]interface{}, error) {
+	g.mu.Lock()
+	fl, ok := g.flights[key]
+	if !ok {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		fl = &flight{done: make(chan struct{}), cancel: cancel}
+		g.flights[key] = fl
+		go func() {
+			fl.data, fl.err = fn(callCtx)
+			cancel()
+			g.mu.Lock()
+			g.forget(key, fl)
+			g.mu.Unlock()
+			close(fl.done)
+		}()
+	}
+	fl.waiters++
+	g.mu.Unlock()
+	select {
+	case <-fl.done:
+		return fl.data, fl.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		fl.waiters--
+		if fl.waiters == 0 {
+			fl.cancel()
+			g.forget(key, fl)
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// The method removes the flight of the key unless it was replaced by a
+// new one. The caller must hold the lock.
+func (g *flightGroup) forget(key string, fl *flight) {
+	if g.flights[key] == fl {
+		delete(g.flights, key)
+	}
+}
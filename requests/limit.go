@@ -0,0 +1,87 @@
+package requests
+
+import (
+	"context"
+	"fmt"
+	"people2/config"
+	"sync"
+	"time"
+)
+
+var (
+	// The maximum number of concurrent calls to all the providers, 0
+	// for no limit.
+	MaxConcurrent = config.Int("REQUESTS_MAX_CONCURRENT", 0)
+	// The maximum time a call waits in the queue for the limit.
+	QueueTimeout = config.Duration("REQUESTS_QUEUE_TIMEOUT", 2*time.Second)
+
+	slotsMu sync.RWMutex
+	slots   = newLimiter(MaxConcurrent)
+)
+
+// The error returned when the call waited in the queue for longer than
+// QueueTimeout.
+type LimitError struct {
+	Provider string
+	Wait     time.Duration
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf(
+		"request to %s was not started within %s: too many concurrent calls",
+		e.Provider, e.Wait,
+	)
+}
+
+// The semaphore of the concurrent calls.
+type limiter chan struct{}
+
+// The function creates the limiter of n concurrent calls, nil for no
+// limit.
+func newLimiter(n int) limiter {
+	if n <= 0 {
+		return nil
+	}
+	return make(limiter, n)
+}
+
+// The function changes the maximum number of concurrent calls. The
+// calls in progress keep their slots of the previous limit.
+func SetMaxConcurrent(n int) {
+	slotsMu.Lock()
+	defer slotsMu.Unlock()
+	MaxConcurrent = n
+	slots = newLimiter(n)
+}
+
+// The function returns the limiter of the current limit.
+func currentSlots() limiter {
+	slotsMu.RLock()
+	defer slotsMu.RUnlock()
+	return slots
+}
+
+// The method waits for a free slot until the queue timeout or the
+// context is done. Returns the function that releases the slot.
+func (l limiter) acquire(ctx context.Context, provider string) (
+	func(), error,
+) {
+	if l == nil {
+		return func() {}, nil
+	}
+	select {
+	case l <- struct{}{}:
+		return func() { <-l }, nil
+	default:
+	}
+	timer := time.NewTimer(QueueTimeout)
+	defer timer.Stop()
+	select {
+	case l <- struct{}{}:
+		return func() { <-l }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		return nil, &LimitError{Provider: provider, Wait: QueueTimeout}
+	}
+}
@@ -0,0 +1,90 @@
+package requests
+
+import (
+	"net/url"
+	"people2/config"
+	"people2/logging"
+	"strings"
+)
+
+var (
+	log = logging.Config
+
+	Agify       = NewProvider("agify", "https://api.agify.io/")
+	Genderize   = NewProvider("genderize", "https://api.genderize.io/")
+	Nationalize = NewProvider("nationalize", "https://api.nationalize.io/")
+)
+
+// The settings of an external API. Tests may point BaseURL at an
+// httptest server.
+type Provider struct {
+	Name    string
+	BaseURL string
+	APIKey  string
+	Params  url.Values
+	Breaker *Breaker
+	quota   *quotaState
+	batch   *batcher
+}
+
+// The function returns all the external APIs.
+func Providers() []*Provider {
+	return []*Provider{Agify, Genderize, Nationalize}
+}
+
+// The function creates the provider settings from the environment
+// variables prefixed with the upper-cased name: <NAME>_URL,
+// <NAME>_API_KEY and <NAME>_PARAMS (example: "country_id=RU&x=1").
+func NewProvider(name, baseURL string) *Provider {
+	f := logging.F()
+	prefix := strings.ToUpper(name)
+	params, err := url.ParseQuery(config.String(prefix+"_PARAMS", ""))
+	if err != nil {
+		log.Warn(f+"invalid "+prefix+"_PARAMS, ignored: ", err)
+		params = url.Values{}
+	}
+	return &Provider{
+		Name:    name,
+		BaseURL: config.String(prefix+"_URL", baseURL),
+		APIKey:  config.String(prefix+"_API_KEY", ""),
+		Params:  params,
+		Breaker: NewBreaker(name),
+		quota:   &quotaState{},
+		batch:   newBatcher(),
+	}
+}
+
+// The method builds the request URL with the extra parameters, the API
+// key and the given query.
+func (p *Provider) URL(query url.Values) string {
+	values := url.Values{}
+	for key, list := range p.Params {
+		values[key] = append([]string(nil), list...)
+	}
+	for key, list := range query {
+		values[key] = append([]string(nil), list...)
+	}
+	if p.APIKey != "" {
+		values.Set("apikey", p.APIKey)
+	}
+	base := p.BaseURL
+	if strings.Contains(base, "?") {
+		return base + "&" + values.Encode()
+	}
+	return base + "?" + values.Encode()
+}
+
+// The function returns the URL without the API key, suitable for logs
+// and error messages.
+func Redact(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	query := u.Query()
+	if query.Has("apikey") {
+		query.Set("apikey", "REDACTED")
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
@@ -0,0 +1,108 @@
+package requests
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The quota of an external API parsed from the X-Rate-Limit headers.
+type Quota struct {
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+	Updated   time.Time `json:"updated"`
+}
+
+// The error returned without calling the provider while its quota is
+// exhausted.
+type QuotaError struct {
+	Provider string
+	Until    time.Time
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf(
+		"quota of %s exhausted until %s",
+		e.Provider, e.Until.Format(time.RFC3339),
+	)
+}
+
+// The last known quota of a provider.
+type quotaState struct {
+	mu    sync.Mutex
+	quota Quota
+	known bool
+}
+
+// The method updates the quota from the response headers. The reset
+// header holds the number of seconds until the quota is renewed.
+func (q *quotaState) update(header http.Header) {
+	limit, errLimit := strconv.Atoi(header.Get("X-Rate-Limit-Limit"))
+	remaining, errRemaining := strconv.Atoi(
+		header.Get("X-Rate-Limit-Remaining"),
+	)
+	reset, errReset := strconv.Atoi(header.Get("X-Rate-Limit-Reset"))
+	if errLimit != nil && errRemaining != nil && errReset != nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	if errLimit == nil {
+		q.quota.Limit = limit
+	}
+	if errRemaining == nil {
+		q.quota.Remaining = remaining
+	}
+	if errReset == nil {
+		q.quota.Reset = now.Add(time.Duration(reset) * time.Second)
+	}
+	q.quota.Updated = now
+	q.known = true
+}
+
+// The method returns a QuotaError while the quota is exhausted.
+func (q *quotaState) check(provider string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.known || q.quota.Remaining > 0 {
+		return nil
+	}
+	if !time.Now().Before(q.quota.Reset) {
+		// The quota is renewed, the next response updates it
+		q.known = false
+		return nil
+	}
+	return &QuotaError{Provider: provider, Until: q.quota.Reset}
+}
+
+func (q *quotaState) get() (Quota, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.quota, q.known
+}
+
+// The method returns the last known quota of the provider.
+func (p *Provider) Quota() (Quota, bool) {
+	if p.quota == nil {
+		return Quota{}, false
+	}
+	return p.quota.get()
+}
+
+// The function returns the last known quotas of all providers.
+func Quotas() map[string]*Quota {
+	quotas := map[string]*Quota{}
+	for _, p := range Providers() {
+		quota, ok := p.Quota()
+		if !ok {
+			quotas[p.Name] = nil
+			continue
+		}
+		quotas[p.Name] = &quota
+	}
+	return quotas
+}
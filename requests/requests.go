@@ -1,82 +1,246 @@
 package requests
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
-	"sync"
+	"net/url"
+	"people2/config"
+	"time"
 )
 
-// Gorutin for obtaining age data based on a name.
-func Age(name string, age *uint8, wg *sync.WaitGroup, ch chan error) {
-	defer wg.Done()
-	url := fmt.Sprintf("https://api.agify.io/?name=%s", name)
-	var reqData map[string]interface{}
-	err := apiReq(url, &reqData)
+var (
+	// The shared client of the external APIs.
+	Client = &http.Client{
+		Timeout: config.Duration("REQUESTS_TIMEOUT", 10*time.Second),
+	}
+	// The deadline of a single call to the external API.
+	CallTimeout = config.Duration("REQUESTS_CALL_TIMEOUT", 5*time.Second)
+)
+
+// The error returned when the external API responds with a non-2xx
+// status code.
+type StatusError struct {
+	URL  string
+	Code int
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf(
+		"request to %s failed with status %d: %s", e.URL, e.Code, e.Body,
+	)
+}
+
+// Obtains age data based on a name.
+func Age(ctx context.Context, name string) (uint8, error) {
+	return AgeIn(ctx, name, "")
+}
+
+// Obtains age data based on a name localized to the country (example:
+// RU), the global data if the country is empty.
+func AgeIn(ctx context.Context, name, countryID string) (uint8, error) {
+	reqData, err := lookup(ctx, Agify, name, localize(countryID))
 	if err != nil {
-		ch <- err
+		return 0, err
 	}
 	target, ok := reqData["age"].(float64) // int float64
 	if !ok {
-		ch <- errors.New("age data not found")
+		return 0, errors.New("age data not found")
 	}
-	*age = uint8(target)
+	return uint8(target), nil
+}
+
+// The gender data with its confidence.
+type GenderData struct {
+	Gender      string
+	Probability float64
+	Count       int
+}
+
+// The country data with its probability.
+type Country struct {
+	CountryID   string
+	Probability float64
+}
+
+// Obtains gender data based on a name.
+func Gender(ctx context.Context, name string) (GenderData, error) {
+	return GenderIn(ctx, name, "")
 }
 
-// Gorutin for obtaining gender data based on a name.
-func Gender(name string, gender *string, wg *sync.WaitGroup, ch chan error) {
-	defer wg.Done()
-	url := fmt.Sprintf("https://api.genderize.io/?name=%s", name)
-	var reqData map[string]interface{}
-	err := apiReq(url, &reqData)
+// Obtains gender data based on a name localized to the country, the
+// global data if the country is empty.
+func GenderIn(
+	ctx context.Context, name, countryID string,
+) (GenderData, error) {
+	reqData, err := lookup(ctx, Genderize, name, localize(countryID))
 	if err != nil {
-		ch <- err
+		return GenderData{}, err
 	}
 	target, ok := reqData["gender"].(string)
 	if !ok {
-		ch <- errors.New("gender data not found")
+		return GenderData{}, errors.New("gender data not found")
 	}
-	//time.Sleep(3 * time.Second)
-	*gender = target
+	probability, _ := reqData["probability"].(float64)
+	count, _ := reqData["count"].(float64)
+	return GenderData{
+		Gender:      target,
+		Probability: probability,
+		Count:       int(count),
+	}, nil
 }
 
-// Gorutin for obtaining nationality data based on a name.
-func Nationality(
-	name string, nation *string, wg *sync.WaitGroup, ch chan error,
-) {
-	defer wg.Done()
-	url := fmt.Sprintf("https://api.nationalize.io/?name=%s", name)
-	var reqData map[string]interface{}
-	err := apiReq(url, &reqData)
+// Obtains nationality data based on a name. Returns all the candidate
+// countries ranked by the provider, the most probable first.
+func Nationality(ctx context.Context, name string) ([]Country, error) {
+	reqData, err := lookup(ctx, Nationalize, name, nil)
 	if err != nil {
-		ch <- err
+		return nil, err
 	}
 	countryList, ok := reqData["country"].([]interface{})
 	if !ok || len(countryList) == 0 {
-		ch <- errors.New("country data not found")
+		return nil, errors.New("country data not found")
 	}
-	firstCountry, ok := countryList[0].(map[string]interface{})
-	if !ok {
-		ch <- errors.New("invalid country data")
+	var countries []Country
+	for _, item := range countryList {
+		country, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("invalid country data")
+		}
+		countryID, ok := country["country_id"].(string)
+		if !ok {
+			return nil, errors.New("country ID not found")
+		}
+		probability, _ := country["probability"].(float64)
+		countries = append(countries, Country{
+			CountryID:   countryID,
+			Probability: probability,
+		})
 	}
-	countryID, ok := firstCountry["country_id"].(string)
-	if !ok {
-		ch <- errors.New("country ID not found")
+	return countries, nil
+}
+
+// The function returns the extra parameters of the localized lookup.
+func localize(countryID string) url.Values {
+	if countryID == "" {
+		return nil
+	}
+	return url.Values{"country_id": {countryID}}
+}
+
+// The function obtains the provider data for a single name. The
+// concurrent lookups of the same name are made once. The name is sent
+// in a batch with the other pending names of the provider if batching
+// is enabled or the lookup is a Bulk one. The response is recorded into
+// the Trace of the context.
+func lookup(
+	ctx context.Context, p *Provider, name string, extra url.Values,
+) (map[string]interface{}, error) {
+	query := url.Values{}
+	for key, list := range extra {
+		query[key] = list
+	}
+	query.Set("name", name)
+	key := p.Name + "?" + query.Encode()
+	reqData, err := flights.do(ctx, key,
+		func(ctx context.Context) (map[string]interface{}, error) {
+			if w := window(ctx); p.batch != nil && w > 0 {
+				return p.batch.add(ctx, p, name, extra, w)
+			}
+			var reqData map[string]interface{}
+			err := apiReq(ctx, p, query, &reqData)
+			return reqData, err
+		},
+	)
+	if err != nil {
+		return nil, err
 	}
-	//time.Sleep(3 * time.Second)
-	*nation = countryID
+	record(ctx, p, query, reqData)
+	return reqData, nil
 }
 
-// The function of processing the request to the specified url. Fills
-// out data map from the response body, otherwise returns an error.
-func apiReq(url string, reqData *map[string]interface{}) error {
-	response, err := http.Get(url)
+// The function of processing the request to the provider with the
+// given query. The transient failures are retried with backoff while
+// the provider breaker allows the calls. Every call waits for a slot
+// first if the concurrent calls are limited, so the wait is not seen by
+// the breaker. Fills out data from the response body, otherwise returns
+// an error.
+func apiReq(
+	ctx context.Context, p *Provider, query url.Values, reqData interface{},
+) error {
+	return retry(ctx, func() error {
+		release, err := currentSlots().acquire(ctx, p.Name)
+		if err != nil {
+			return err
+		}
+		defer release()
+		if p.quota != nil {
+			if err := p.quota.check(p.Name); err != nil {
+				return err
+			}
+		}
+		if err := p.Breaker.Allow(); err != nil {
+			return err
+		}
+		err = call(ctx, p, query, reqData)
+		switch {
+		case err != nil && ctx.Err() != nil:
+			// The caller gave up, the provider is not to blame
+			p.Breaker.Release()
+		case errors.As(err, new(*QuotaError)):
+			// The provider is healthy, the quota is over
+			p.Breaker.Release()
+		case err != nil && Retryable(err):
+			p.Breaker.Failure()
+		default:
+			p.Breaker.Success()
+		}
+		return err
+	})
+}
+
+// The function of a single call to the provider within the call
+// deadline.
+func call(
+	ctx context.Context, p *Provider, query url.Values, reqData interface{},
+) error {
+	ctx, cancel := context.WithTimeout(ctx, CallTimeout)
+	defer cancel()
+	target := p.URL(query)
+	request, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return err
+	}
+	response, err := Client.Do(request)
 	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = Redact(urlErr.URL)
+		}
 		return err
 	}
 	defer response.Body.Close()
-	err = json.NewDecoder(response.Body).Decode(&reqData)
+	if p.quota != nil {
+		p.quota.update(response.Header)
+		if response.StatusCode == 429 {
+			if err := p.quota.check(p.Name); err != nil {
+				return err
+			}
+		}
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return &StatusError{
+			URL:  Redact(target),
+			Code: response.StatusCode,
+			Body: string(body),
+		}
+	}
+	err = json.NewDecoder(response.Body).Decode(reqData)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,65 @@
+package requests
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"people2/config"
+	"time"
+)
+
+var (
+	// The maximum number of calls including the first one.
+	RetryAttempts = config.Int("RETRY_ATTEMPTS", 3)
+	// The base and the maximum delay of the exponential backoff.
+	RetryBaseDelay = config.Duration("RETRY_BASE_DELAY", 200*time.Millisecond)
+	RetryMaxDelay  = config.Duration("RETRY_MAX_DELAY", 2*time.Second)
+)
+
+// The function reports whether the failed call may succeed if repeated:
+// network errors, timeouts of a single call, 429 and 5xx responses.
+func Retryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code == 429 || statusErr.Code >= 500
+	}
+	if errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// The function calls fn until it succeeds, returns a non-retryable
+// error or the attempts are over. The delays grow exponentially with
+// full jitter.
+func retry(ctx context.Context, fn func() error) error {
+	var err error
+	for attempt := 0; attempt < RetryAttempts; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(backoff(attempt))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		}
+		err = fn()
+		if err == nil || ctx.Err() != nil || !Retryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+func backoff(attempt int) time.Duration {
+	delay := RetryBaseDelay << (attempt - 1)
+	if delay <= 0 || delay > RetryMaxDelay {
+		delay = RetryMaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
@@ -0,0 +1,48 @@
+package requests
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"time"
+)
+
+// The record of the provider response for a single name: the request
+// URL without the API key, the response body and the time it was
+// received.
+type Trace struct {
+	Provider string
+	URL      string
+	Response json.RawMessage
+	Time     time.Time
+}
+
+type traceKey struct{}
+
+// The function returns the context that records the next response
+// looked up with it into the returned Trace.
+func WithTrace(ctx context.Context) (context.Context, *Trace) {
+	trace := &Trace{}
+	return context.WithValue(ctx, traceKey{}, trace), trace
+}
+
+// The function records the response of the provider if the context
+// asks for it. In the batch mode the URL is the one of the single name
+// so that the other names of the batch are not disclosed.
+func record(
+	ctx context.Context, p *Provider, query url.Values,
+	reqData map[string]interface{},
+) {
+	trace, ok := ctx.Value(traceKey{}).(*Trace)
+	if !ok {
+		return
+	}
+	response, err := json.Marshal(reqData)
+	if err != nil {
+		return
+	}
+	trace.Provider = p.Name
+	trace.URL = Redact(p.URL(query))
+	trace.Response = response
+	trace.Time = time.Now()
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"people2/requests"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing the provider settings in the requests package.
+func TestProviderConfig(t *testing.T) {
+	var query url.Values
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			query = r.URL.Query()
+			w.Write([]byte(`{"name":"Ivan","age":42}`))
+		},
+	))
+	defer srv.Close()
+	saved := *requests.Agify
+	defer func() { *requests.Agify = saved }()
+	requests.Agify.BaseURL = srv.URL
+	requests.Agify.APIKey = "secret"
+	requests.Agify.Params = url.Values{"country_id": {"RU"}}
+
+	age, err := requests.Age(context.Background(), "Ivan")
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(42), age)
+	assert.Equal(t, "Ivan", query.Get("name"))
+	assert.Equal(t, "secret", query.Get("apikey"))
+	assert.Equal(t, "RU", query.Get("country_id"))
+}
+
+// Testing the handling of non-2xx responses in the requests package.
+func TestProviderStatusError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(500)
+			w.Write([]byte(`{"error":"internal"}`))
+		},
+	))
+	defer srv.Close()
+	saved := *requests.Genderize
+	defer func() { *requests.Genderize = saved }()
+	requests.Genderize.BaseURL = srv.URL
+	requests.Genderize.APIKey = "secret"
+	requests.Genderize.Breaker = requests.NewBreaker("genderize")
+	attempts := requests.RetryAttempts
+	defer func() { requests.RetryAttempts = attempts }()
+	requests.RetryAttempts = 1
+
+	_, err := requests.Gender(context.Background(), "Ivan")
+	var statusErr *requests.StatusError
+	assert.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, 500, statusErr.Code)
+	assert.False(t, strings.Contains(err.Error(), "secret"))
+}
+
+// Testing the recording of the responses in the requests package.
+func TestProviderTrace(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"name":"ivan","age":42}`))
+		},
+	))
+	defer srv.Close()
+	saved := *requests.Agify
+	defer func() { *requests.Agify = saved }()
+	requests.Agify.BaseURL = srv.URL
+	requests.Agify.APIKey = "secret"
+	requests.Agify.Breaker = requests.NewBreaker("agify")
+
+	ctx, trace := requests.WithTrace(context.Background())
+	age, err := requests.Age(ctx, "ivan")
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(42), age)
+	assert.Equal(t, "agify", trace.Provider)
+	assert.False(t, strings.Contains(trace.URL, "secret"))
+	assert.JSONEq(t, `{"name":"ivan","age":42}`, string(trace.Response))
+	assert.False(t, trace.Time.IsZero())
+}
+
+// Testing the retry of transient failures in the requests package.
+func TestProviderRetry(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if calls < 3 {
+				w.WriteHeader(503)
+				return
+			}
+			w.Write([]byte(`{"country":[{"country_id":"RU"}]}`))
+		},
+	))
+	defer srv.Close()
+	saved := *requests.Nationalize
+	defer func() { *requests.Nationalize = saved }()
+	requests.Nationalize.BaseURL = srv.URL
+	requests.Nationalize.Breaker = requests.NewBreaker("nationalize")
+	delay := requests.RetryBaseDelay
+	defer func() { requests.RetryBaseDelay = delay }()
+	requests.RetryBaseDelay = time.Millisecond
+
+	nation, err := requests.Nationality(context.Background(), "Ivan")
+	assert.NoError(t, err)
+	assert.Equal(t, "RU", nation[0].CountryID)
+	assert.Equal(t, 3, calls)
+}
+
+// Testing the circuit breaker states in the requests package.
+func TestBreaker(t *testing.T) {
+	b := requests.NewBreaker("test")
+	b.Threshold = 2
+	b.Cooldown = 10 * time.Millisecond
+
+	assert.NoError(t, b.Allow())
+	b.Failure()
+	assert.Equal(t, requests.Closed, b.Status().State)
+	b.Failure()
+	assert.Equal(t, requests.Open, b.Status().State)
+	var breakerErr *requests.BreakerError
+	assert.True(t, errors.As(b.Allow(), &breakerErr))
+
+	// The single trial call after the cooldown
+	time.Sleep(15 * time.Millisecond)
+	assert.NoError(t, b.Allow())
+	assert.Equal(t, requests.HalfOpen, b.Status().State)
+	assert.Error(t, b.Allow())
+	b.Success()
+	assert.Equal(t, requests.Closed, b.Status().State)
+}
+
+// Testing the trial call given up by the caller in the requests package.
+func TestBreakerRelease(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		},
+	))
+	defer srv.Close()
+	saved := *requests.Agify
+	defer func() { *requests.Agify = saved }()
+	requests.Agify.BaseURL = srv.URL
+	b := requests.NewBreaker("agify")
+	b.Threshold = 1
+	b.Cooldown = 10 * time.Millisecond
+	requests.Agify.Breaker = b
+
+	assert.NoError(t, b.Allow())
+	b.Failure()
+	time.Sleep(15 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 20*time.Millisecond,
+	)
+	defer cancel()
+	_, err := requests.Age(ctx, "Ivan")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The shared lookup is given up right after the caller, then the
+	// trial is released and the breaker is neither closed nor stuck
+	assert.Eventually(t, func() bool {
+		return b.Allow() == nil
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, requests.HalfOpen, b.Status().State)
+}
+
+// Testing the quota tracking in the requests package.
+func TestProviderQuota(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.Header().Set("X-Rate-Limit-Limit", "1000")
+			w.Header().Set("X-Rate-Limit-Remaining", "0")
+			w.Header().Set("X-Rate-Limit-Reset", "3600")
+			w.Write([]byte(`{"age":42}`))
+		},
+	))
+	defer srv.Close()
+	saved := *requests.Agify
+	defer func() { *requests.Agify = saved }()
+	*requests.Agify = *requests.NewProvider("agify", "")
+	requests.Agify.BaseURL = srv.URL
+
+	_, err := requests.Age(context.Background(), "Ivan")
+	assert.NoError(t, err)
+	quota, ok := requests.Agify.Quota()
+	assert.True(t, ok)
+	assert.Equal(t, 1000, quota.Limit)
+	assert.Equal(t, 0, quota.Remaining)
+
+	// The exhausted quota stops the calls
+	_, err = requests.Age(context.Background(), "Ivan")
+	var quotaErr *requests.QuotaError
+	assert.True(t, errors.As(err, &quotaErr))
+	assert.Equal(t, 1, calls)
+}
+
+// Testing the grouping of concurrent lookups in the requests package.
+func TestProviderBatch(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			var list []map[string]interface{}
+			for i, name := range r.URL.Query()["name[]"] {
+				list = append(list, map[string]interface{}{
+					"name": name,
+					"age":  20 + i,
+				})
+			}
+			json.NewEncoder(w).Encode(list)
+		},
+	))
+	defer srv.Close()
+	saved := *requests.Agify
+	defer func() { *requests.Agify = saved }()
+	*requests.Agify = *requests.NewProvider("agify", "")
+	requests.Agify.BaseURL = srv.URL
+	window := requests.BatchWindow
+	defer func() { requests.BatchWindow = window }()
+	requests.BatchWindow = 50 * time.Millisecond
+
+	names := []string{"Ivan", "Anna", "Oleg", "Ivan"}
+	ages := make([]uint8, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			age, err := requests.Age(context.Background(), name)
+			assert.NoError(t, err)
+			ages[i] = age
+		}(i, name)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Equal(t, ages[0], ages[3])
+	assert.NotZero(t, ages[1])
+
+	// The bulk lookups are batched without the window of the single ones
+	requests.BatchWindow = 0
+	bulk := requests.BulkWindow
+	defer func() { requests.BulkWindow = bulk }()
+	requests.BulkWindow = 50 * time.Millisecond
+	ctx := requests.Bulk(context.Background())
+	for _, name := range []string{"Petr", "Olga"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			_, err := requests.Age(ctx, name)
+			assert.NoError(t, err)
+		}(name)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+// Testing the coalescing of the identical lookups in the requests
+// package.
+func TestProviderSingleflight(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			<-release
+			w.Write([]byte(`{"age":42}`))
+		},
+	))
+	defer srv.Close()
+	saved := *requests.Agify
+	defer func() { *requests.Agify = saved }()
+	requests.Agify.BaseURL = srv.URL
+	requests.Agify.Breaker = requests.NewBreaker("agify")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			age, err := requests.Age(context.Background(), "Ivan")
+			assert.NoError(t, err)
+			assert.Equal(t, uint8(42), age)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+// Testing the lookup of the name whose previous waiters all gave up in
+// the requests package.
+func TestProviderSingleflightRejoin(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) == 1 {
+				<-r.Context().Done()
+				return
+			}
+			w.Write([]byte(`{"age":42}`))
+		},
+	))
+	defer srv.Close()
+	saved := *requests.Agify
+	defer func() { *requests.Agify = saved }()
+	requests.Agify.BaseURL = srv.URL
+	requests.Agify.Breaker = requests.NewBreaker("agify")
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 20*time.Millisecond,
+	)
+	defer cancel()
+	_, err := requests.Age(ctx, "Ivan")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The new caller does not join the cancelled lookup
+	age, err := requests.Age(context.Background(), "Ivan")
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(42), age)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+// Testing the limit of the concurrent calls in the requests package.
+func TestProviderLimit(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			<-release
+			w.Write([]byte(`{"age":42}`))
+		},
+	))
+	defer srv.Close()
+	saved := *requests.Agify
+	defer func() { *requests.Agify = saved }()
+	requests.Agify.BaseURL = srv.URL
+	b := requests.NewBreaker("agify")
+	requests.Agify.Breaker = b
+	assert.NoError(t, b.Allow())
+	b.Failure()
+	limit, timeout := requests.MaxConcurrent, requests.QueueTimeout
+	defer func() {
+		requests.SetMaxConcurrent(limit)
+		requests.QueueTimeout = timeout
+	}()
+	requests.SetMaxConcurrent(1)
+	requests.QueueTimeout = 20 * time.Millisecond
+
+	done := make(chan error)
+	go func() {
+		_, err := requests.Age(context.Background(), "Ivan")
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	_, err := requests.Age(context.Background(), "Petr")
+	var limitErr *requests.LimitError
+	assert.True(t, errors.As(err, &limitErr))
+	// The wait for a slot is not seen by the breaker
+	assert.Equal(t, 1, b.Status().Failures)
+	close(release)
+	assert.NoError(t, <-done)
+}
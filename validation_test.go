@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"people2/models"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Testing the structured validation errors in the models package.
+func TestValidationErrors(t *testing.T) {
+	dataMsg := models.FullName{Name: "I", Surname: "Ivanov1"}
+	var errs models.ValidationErrors
+	assert.True(t, errors.As(dataMsg.IsValid(), &errs))
+	assert.Equal(t, models.ValidationErrors{
+		{
+			Field:   "name",
+			Code:    models.CodeTooShort,
+			Message: "name is too short",
+			Params:  map[string]interface{}{"min": 2},
+		},
+		{
+			Field:   "surname",
+			Code:    models.CodeInvalidCharacters,
+			Message: "surname contains invalid characters",
+		},
+	}, errs)
+	assert.Equal(
+		t, "name is too short, surname contains invalid characters",
+		errs.Error(),
+	)
+
+	entry := models.Entry{
+		Name:        "Ivan",
+		Surname:     "Ivanov",
+		Age:         130,
+		Gender:      "male",
+		Nationality: "Russia",
+	}
+	assert.True(t, errors.As(entry.IsValid(), &errs))
+	var codes []string
+	for _, item := range errs {
+		codes = append(codes, item.Field+":"+item.Code)
+	}
+	assert.Equal(
+		t,
+		[]string{"age:out_of_range", "nationality:invalid_format"},
+		codes,
+	)
+
+	dataMsg = models.FullName{Name: "Ivan", Surname: "Ivanov"}
+	assert.NoError(t, dataMsg.IsValid())
+}
+
+// Testing the Unicode-aware validation of the name parts.
+func TestNameValidation(t *testing.T) {
+	for _, name := range []string{
+		"Anne-Marie", "O'Connor", "D’Artagnan", "Ёлкин", "José", "Ørsted",
+		"Jose\u0301", "van der Berg", "Абдурахмангаджиевамагомедовна",
+	} {
+		dataMsg := models.FullName{Name: name, Surname: name}
+		assert.NoError(t, dataMsg.IsValid(), name)
+	}
+
+	codes := map[string]string{
+		"-Anna":     models.CodeInvalidSeparator,
+		"Anna-":     models.CodeInvalidSeparator,
+		"Anne--Ma":  models.CodeInvalidSeparator,
+		"Ann4":      models.CodeInvalidCharacters,
+		"\u0301Ann": models.CodeInvalidCharacters,
+		"Ανδρέας":   models.CodeScriptNotAllowed,
+		"Й":         models.CodeTooShort,
+	}
+	for name, code := range codes {
+		dataMsg := models.FullName{Name: "Ivan", Surname: "Ivanov"}
+		dataMsg.Patronymic = name
+		var errs models.ValidationErrors
+		assert.True(t, errors.As(dataMsg.IsValid(), &errs), name)
+		if assert.Len(t, errs, 1, name) {
+			assert.Equal(t, "patronymic", errs[0].Field, name)
+			assert.Equal(t, code, errs[0].Code, name)
+		}
+	}
+
+	dataMsg := models.FullName{
+		Name:    "Ivan",
+		Surname: strings.Repeat("Я", models.NameMaxLength+1),
+	}
+	var errs models.ValidationErrors
+	assert.True(t, errors.As(dataMsg.IsValid(), &errs))
+	assert.Equal(t, models.CodeTooLong, errs[0].Code)
+}
+
+// Testing the detection and normalization of the mixed script names.
+func TestMixedScript(t *testing.T) {
+	// Cyrillic "а" inside the Latin name
+	dataMsg := models.FullName{Name: "Ivаn", Surname: "Ivanov"}
+	var errs models.ValidationErrors
+	assert.Nil(t, dataMsg.Normalize())
+	assert.True(t, errors.As(dataMsg.IsValid(), &errs))
+	assert.Equal(t, "name", errs[0].Field)
+	assert.Equal(t, models.CodeMixedScript, errs[0].Code)
+
+	mode := models.MixedScriptMode
+	models.MixedScriptMode = "normalize"
+	defer func() { models.MixedScriptMode = mode }()
+	assert.Equal(
+		t, models.Spellings{"name": "Ivаn"}, dataMsg.Normalize(),
+	)
+	assert.Equal(t, "Ivan", dataMsg.Name)
+	assert.NoError(t, dataMsg.IsValid())
+
+	// Latin "o" inside the Cyrillic surname
+	entry := models.Entry{Name: "Пётр", Surname: "Сидoрoв"}
+	entry.Normalize()
+	assert.Equal(t, "Сидоров", entry.Surname)
+	assert.Equal(t, models.Spellings{"surname": "Сидoрoв"}, entry.MixedScript)
+
+	// The spelling of the unchanged part is kept by the update
+	upd := models.Entry{Name: "Pyotr", Surname: "Сидоров"}
+	upd.Normalize()
+	entry.Rename(&upd)
+	assert.Equal(t, "Pyotr", entry.Name)
+	assert.Equal(t, models.Spellings{"surname": "Сидoрoв"}, entry.MixedScript)
+	upd = models.Entry{Name: "Pyotr", Surname: "Sidоrov"}
+	upd.Normalize()
+	entry.Rename(&upd)
+	assert.Equal(t, "Sidorov", entry.Surname)
+	assert.Equal(t, models.Spellings{"surname": "Sidоrov"}, entry.MixedScript)
+	upd = models.Entry{Name: "Pyotr", Surname: "Petrov"}
+	upd.Normalize()
+	entry.Rename(&upd)
+	assert.Nil(t, entry.MixedScript)
+
+	// No look-alikes for "Ж" and "k"
+	dataMsg = models.FullName{Name: "Жak", Surname: "Ivanov"}
+	assert.Nil(t, dataMsg.Normalize())
+	assert.True(t, errors.As(dataMsg.IsValid(), &errs))
+	assert.Equal(t, models.CodeMixedScript, errs[0].Code)
+
+	// Stored as JSON
+	value, err := entry.MixedScript.Value()
+	assert.NoError(t, err)
+	var spellings models.Spellings
+	assert.NoError(t, spellings.Scan(value))
+	assert.Equal(t, entry.MixedScript, spellings)
+}